@@ -0,0 +1,15 @@
+package migrations
+
+import (
+	"registry/pkg/store"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		return store.Consolidate(daos.New(db))
+	}, nil)
+}
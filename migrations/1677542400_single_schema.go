@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"errors"
+
+	"registry/pkg/blob"
+	"registry/pkg/store"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		if app == nil {
+			return errors.New("migrations are not bound to an app")
+		}
+
+		// tarballs are moved in whatever storage the app uses, S3 or the
+		// local storage directory
+		storage, err := blob.For(app)
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		dao := daos.New(db)
+		if err := store.MoveLegacy(dao, storage); err != nil {
+			return err
+		}
+
+		return store.CreateIndexes(dao)
+	}, nil)
+}
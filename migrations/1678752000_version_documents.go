@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"errors"
+
+	"registry/pkg/blob"
+	"registry/pkg/helpers"
+	"registry/pkg/store"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		if app == nil {
+			return errors.New("migrations are not bound to an app")
+		}
+
+		dao := daos.New(db)
+
+		collection, _ := dao.FindCollectionByNameOrId(store.VersionsCollection)
+		if collection == nil {
+			return nil
+		}
+
+		// collections moved by the single schema migration already have the
+		// fields, but their versions still need to be filled
+		if collection.Schema.GetFieldByName("readme") == nil {
+			for _, field := range store.DocumentFields() {
+				collection.Schema.AddField(field)
+			}
+
+			if err := dao.SaveCollection(collection); err != nil {
+				return err
+			}
+		}
+
+		records, err := dao.FindRecordsByExpr(store.VersionsCollection, dbx.HashExp{"readme": ""})
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			readme, changelog := helpers.Documents(blob.Tarball(app, record))
+			if err := store.SaveDocuments(dao, record, readme, changelog); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, nil)
+}
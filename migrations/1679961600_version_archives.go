@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"registry/pkg/store"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, _ := dao.FindCollectionByNameOrId(store.VersionsCollection)
+		if collection == nil || collection.Schema.GetFieldByName("archive") != nil {
+			return nil
+		}
+
+		collection.Schema.AddField(store.ArchiveField())
+		return dao.SaveCollection(collection)
+	}, nil)
+}
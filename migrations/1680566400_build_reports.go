@@ -0,0 +1,15 @@
+package migrations
+
+import (
+	"registry/pkg/builds"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		return builds.EnsureReports(daos.New(db))
+	}, nil)
+}
@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"errors"
+	"log"
+
+	"registry/pkg/blob"
+	"registry/pkg/store"
+	"registry/pkg/templates"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		if app == nil {
+			return errors.New("migrations are not bound to an app")
+		}
+
+		dao := daos.New(db)
+
+		collection, _ := dao.FindCollectionByNameOrId(store.VersionsCollection)
+		if collection == nil || collection.Schema.GetFieldByName("archive") == nil {
+			return nil
+		}
+
+		packages, err := dao.FindRecordsByExpr(store.PackagesCollection, dbx.HashExp{"type": "template"})
+		if err != nil {
+			return err
+		}
+
+		// archives of template versions are built once here, the catalog
+		// only reads them
+		for _, pkg := range packages {
+			records, err := dao.FindRecordsByExpr(store.VersionsCollection, dbx.HashExp{"package": pkg.Id})
+			if err != nil {
+				return err
+			}
+
+			for _, record := range records {
+				archive, err := templates.NewArchive(blob.Tarball(app, record), pkg.GetString("name"))
+				if err != nil {
+					log.Printf("templates: unable to archive '%s@%s': %v", pkg.GetString("name"), record.GetString("version"), err)
+					continue
+				}
+
+				if err := templates.SaveArchive(dao, record, archive); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}, nil)
+}
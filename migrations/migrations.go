@@ -0,0 +1,11 @@
+package migrations
+
+import "github.com/pocketbase/pocketbase/core"
+
+var app core.App
+
+// Bind gives the migrations access to the app, for the ones that also need
+// to move files in the data directory.
+func Bind(a core.App) {
+	app = a
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"log"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+const auditCollection = "just_audit"
+
+func collection(app core.App) (*models.Collection, error) {
+	if exists, _ := app.Dao().FindCollectionByNameOrId(auditCollection); exists != nil {
+		return exists, nil
+	}
+
+	collection := &models.Collection{}
+	form := forms.NewCollectionUpsert(app, collection)
+	form.Name = auditCollection
+	form.Type = models.CollectionTypeBase
+	form.ListRule = nil
+	form.ViewRule = nil
+	form.CreateRule = nil
+	form.UpdateRule = nil
+	form.DeleteRule = nil
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "package",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "action",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "actor",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "target",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "details",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Unique:   false,
+	})
+
+	if err := form.Submit(); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func Actor(c echo.Context) string {
+	if admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		return "admin:" + admin.Id
+	}
+
+	if user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record); user != nil {
+		return user.Id
+	}
+
+	return "anonymous"
+}
+
+func Log(app core.App, c echo.Context, packageName string, action string, target string, details map[string]any) {
+	collection, err := collection(app)
+	if err != nil {
+		log.Printf("audit: %v", err)
+		return
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("package", packageName)
+	record.Set("action", action)
+	record.Set("actor", Actor(c))
+	record.Set("target", target)
+	record.Set("details", details)
+
+	if err := app.Dao().SaveRecord(record); err != nil {
+		log.Printf("audit: unable to record %s on %s: %v", action, packageName, err)
+	}
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"registry/pkg/orgs"
+	"registry/pkg/parse"
+	"registry/pkg/store"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"golang.org/x/exp/slices"
+)
+
+func packageAccess(app core.App, packageName string) ([]string, bool) {
+	pkg, err := store.FindPackage(app, packageName)
+	if err != nil {
+		return nil, false
+	}
+
+	return pkg.GetStringSlice("access"), true
+}
+
+func PackageRole(app core.App, userId string, packageName string) string {
+	roles := []string{}
+
+	if access, exists := packageAccess(app, packageName); exists {
+		switch slices.Index(access, userId) {
+		case -1:
+		case 0:
+			roles = append(roles, orgs.RoleOwner)
+		default:
+			roles = append(roles, orgs.RoleMaintainer)
+		}
+	}
+
+	if scope := parse.Scope(packageName); scope != "" {
+		roles = append(roles, orgs.Role(app, userId, scope))
+	}
+
+	return orgs.Highest(roles...)
+}
+
+func caller(c echo.Context) (*models.Record, bool) {
+	admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin)
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+
+	return user, admin != nil
+}
+
+func HasRole(app core.App, c echo.Context, packageName string, role string) bool {
+	user, isAdmin := caller(c)
+	if isAdmin {
+		return true
+	}
+
+	if user == nil {
+		return false
+	}
+
+	return orgs.Rank(PackageRole(app, user.Id, packageName)) >= orgs.Rank(role)
+}
+
+func CanPublish(app core.App, c echo.Context, packageName string) bool {
+	if HasRole(app, c, packageName, orgs.RoleMaintainer) {
+		return true
+	}
+
+	if _, exists := packageAccess(app, packageName); exists {
+		return false
+	}
+
+	user, _ := caller(c)
+	return user != nil && parse.Scope(packageName) == ""
+}
+
+func CanRead(app core.App, c echo.Context, packageName string) bool {
+	return HasScope(c, "read:private") && HasRole(app, c, packageName, orgs.RoleRead)
+}
@@ -0,0 +1,370 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"registry/pkg/response"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const TokenPrefix = "jrt_"
+const ContextTokenKey = "apiToken"
+
+const tokensCollection = "just_tokens"
+
+// TrustedProxies are the reverse proxies whose forwarded headers tell the
+// address of a client. Requests from anywhere else use the remote address.
+var TrustedProxies []string
+
+type TokenInfo struct {
+	Id       string         `json:"id"`
+	Name     string         `json:"name"`
+	Scopes   []string       `json:"scopes"`
+	IPs      []string       `json:"ips"`
+	Expires  types.DateTime `json:"expires"`
+	LastUsed types.DateTime `json:"lastUsed"`
+	Created  types.DateTime `json:"created"`
+}
+
+type TokenRequest struct {
+	Name    string         `json:"name" form:"name"`
+	Scopes  []string       `json:"scopes" form:"scopes"`
+	IPs     []string       `json:"ips" form:"ips"`
+	Expires types.DateTime `json:"expires" form:"expires"`
+}
+
+func hashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+func tokenCollection(app core.App) (*models.Collection, error) {
+	if exists, _ := app.Dao().FindCollectionByNameOrId(tokensCollection); exists != nil {
+		return exists, nil
+	}
+
+	auth, err := app.Dao().FindCollectionByNameOrId("just_auth_system")
+	if err != nil {
+		return nil, err
+	}
+
+	collection := &models.Collection{}
+	form := forms.NewCollectionUpsert(app, collection)
+	form.Name = tokensCollection
+	form.Type = models.CollectionTypeBase
+	form.ListRule = nil
+	form.ViewRule = nil
+	form.CreateRule = nil
+	form.UpdateRule = nil
+	form.DeleteRule = nil
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "user",
+		Type:     schema.FieldTypeRelation,
+		Required: true,
+		Unique:   false,
+		Options: &schema.RelationOptions{
+			MaxSelect:     types.Pointer(1),
+			CollectionId:  auth.Id,
+			CascadeDelete: true,
+		},
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "name",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "hash",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   true,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "scopes",
+		Type:     schema.FieldTypeJson,
+		Required: true,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "ips",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "expires",
+		Type:     schema.FieldTypeDate,
+		Required: false,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "lastUsed",
+		Type:     schema.FieldTypeDate,
+		Required: false,
+		Unique:   false,
+	})
+
+	if err := form.Submit(); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func validScope(scope string) bool {
+	switch {
+	case scope == "admin", scope == "read:private":
+		return true
+	case strings.HasPrefix(scope, "publish:"):
+		_, err := path.Match(strings.TrimPrefix(scope, "publish:"), "")
+		return len(scope) > len("publish:") && err == nil
+	}
+	return false
+}
+
+func CreateToken(app core.App, user *models.Record, req TokenRequest) (string, *TokenInfo, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return "", nil, errors.New("token name is required")
+	}
+
+	if len(req.Scopes) == 0 {
+		return "", nil, errors.New("at least one scope is required")
+	}
+
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			return "", nil, fmt.Errorf("invalid scope '%s'", scope)
+		}
+	}
+
+	for _, ip := range req.IPs {
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return "", nil, fmt.Errorf("invalid ip or cidr '%s'", ip)
+			}
+		}
+	}
+
+	if !req.Expires.IsZero() && req.Expires.Time().Before(time.Now()) {
+		return "", nil, errors.New("expiry must be in the future")
+	}
+
+	collection, err := tokenCollection(app)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if req.IPs == nil {
+		req.IPs = []string{}
+	}
+
+	token := TokenPrefix + security.RandomString(40)
+	record := models.NewRecord(collection)
+	record.Set("user", user.Id)
+	record.Set("name", req.Name)
+	record.Set("hash", hashToken(token))
+	record.Set("scopes", req.Scopes)
+	record.Set("ips", req.IPs)
+	if !req.Expires.IsZero() {
+		record.Set("expires", req.Expires)
+	}
+
+	if err := app.Dao().SaveRecord(record); err != nil {
+		return "", nil, err
+	}
+
+	return token, tokenInfo(record), nil
+}
+
+func ListTokens(app core.App, user *models.Record) ([]*TokenInfo, error) {
+	tokens := []*TokenInfo{}
+
+	if exists, _ := app.Dao().FindCollectionByNameOrId(tokensCollection); exists == nil {
+		return tokens, nil
+	}
+
+	records, err := app.Dao().FindRecordsByExpr(tokensCollection, dbx.HashExp{"user": user.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		tokens = append(tokens, tokenInfo(record))
+	}
+
+	return tokens, nil
+}
+
+func RevokeToken(app core.App, user *models.Record, id string) error {
+	record, err := app.Dao().FindRecordById(tokensCollection, id)
+	if err != nil || record.GetString("user") != user.Id {
+		return errors.New("token not found")
+	}
+
+	return app.Dao().DeleteRecord(record)
+}
+
+func tokenInfo(record *models.Record) *TokenInfo {
+	info := &TokenInfo{
+		Id:       record.Id,
+		Name:     record.GetString("name"),
+		Expires:  record.GetDateTime("expires"),
+		LastUsed: record.GetDateTime("lastUsed"),
+		Created:  record.Created,
+	}
+
+	_ = record.UnmarshalJSONField("scopes", &info.Scopes)
+	_ = record.UnmarshalJSONField("ips", &info.IPs)
+
+	return info
+}
+
+func allowedIP(ips []string, remote string) bool {
+	if len(ips) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(remote)
+	for _, allowed := range ips {
+		if allowed == remote {
+			return true
+		}
+
+		if _, network, err := net.ParseCIDR(allowed); err == nil && ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP is the address a request came from. Behind trusted proxies it
+// is the rightmost X-Forwarded-For entry that isn't a proxy itself, the
+// entries left of it are sent by the client and can't be trusted.
+func clientIP(c echo.Context) string {
+	remote, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		remote = c.Request().RemoteAddr
+	}
+
+	if len(TrustedProxies) == 0 || !allowedIP(TrustedProxies, remote) {
+		return remote
+	}
+
+	forwarded := []string{}
+	for _, header := range c.Request().Header.Values(echo.HeaderXForwardedFor) {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+
+		if !allowedIP(TrustedProxies, ip) {
+			return ip
+		}
+		remote = ip
+	}
+
+	return remote
+}
+
+func LoadToken(app core.App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+			if !strings.HasPrefix(token, TokenPrefix) {
+				return next(c)
+			}
+
+			record, err := app.Dao().FindFirstRecordByData(tokensCollection, "hash", hashToken(token))
+			if err != nil {
+				return c.JSON(401, response.ErrorFromString(401, "invalid api token"))
+			}
+
+			info := tokenInfo(record)
+			if !info.Expires.IsZero() && info.Expires.Time().Before(time.Now()) {
+				return c.JSON(401, response.ErrorFromString(401, "api token has expired"))
+			}
+
+			if !allowedIP(info.IPs, clientIP(c)) {
+				return c.JSON(401, response.ErrorFromString(401, "api token cannot be used from this address"))
+			}
+
+			user, err := app.Dao().FindRecordById("just_auth_system", record.GetString("user"))
+			if err != nil {
+				return c.JSON(401, response.ErrorFromString(401, "invalid api token"))
+			}
+
+			if time.Since(info.LastUsed.Time()) > time.Minute {
+				record.Set("lastUsed", types.NowDateTime())
+				if err := app.Dao().SaveRecord(record); err != nil {
+					log.Printf("auth: unable to record token usage: %v", err)
+				}
+			}
+
+			c.Set(apis.ContextAuthRecordKey, user)
+			c.Set(ContextTokenKey, info)
+
+			return next(c)
+		}
+	}
+}
+
+func HasScope(c echo.Context, scope string) bool {
+	info, _ := c.Get(ContextTokenKey).(*TokenInfo)
+	if info == nil {
+		return true
+	}
+
+	for _, granted := range info.Scopes {
+		if granted == "admin" || granted == scope {
+			return true
+		}
+
+		if strings.HasPrefix(granted, "publish:") && strings.HasPrefix(scope, "publish:") {
+			if matched, _ := path.Match(strings.TrimPrefix(granted, "publish:"), strings.TrimPrefix(scope, "publish:")); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !HasScope(c, scope) {
+				return c.JSON(403, response.ErrorFromString(403, fmt.Sprintf("api token requires the '%s' scope", scope)))
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v5"
+)
+
+func TestClientIP(t *testing.T) {
+	TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+	defer func() { TrustedProxies = nil }()
+
+	for _, tc := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{name: "direct", remote: "203.0.113.7:4000", want: "203.0.113.7"},
+		{name: "direct with spoofed header", remote: "203.0.113.7:4000", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "proxied", remote: "127.0.0.1:4000", forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "proxied with spoofed header", remote: "127.0.0.1:4000", forwarded: []string{"198.51.100.1, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "proxy chain", remote: "127.0.0.1:4000", forwarded: []string{"198.51.100.1, 203.0.113.7", "10.1.2.3"}, want: "203.0.113.7"},
+		{name: "proxied without header", remote: "127.0.0.1:4000", want: "127.0.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remote
+			for _, value := range tc.forwarded {
+				req.Header.Add(echo.HeaderXForwardedFor, value)
+			}
+
+			if got := clientIP(echo.New().NewContext(req, httptest.NewRecorder())); got != tc.want {
+				t.Errorf("clientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSpoofedAllowlist(t *testing.T) {
+	TrustedProxies = []string{"127.0.0.1"}
+	defer func() { TrustedProxies = nil }()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "127.0.0.1:4000"
+	// nginx appends the address it saw to whatever the client sent
+	req.Header.Set(echo.HeaderXForwardedFor, "192.0.2.10, 203.0.113.7")
+
+	if allowedIP([]string{"192.0.2.0/24"}, clientIP(echo.New().NewContext(req, httptest.NewRecorder()))) {
+		t.Error("a forwarded address sent by the client passed the allowlist")
+	}
+}
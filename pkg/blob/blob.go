@@ -0,0 +1,276 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/settings"
+	gblob "gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/blob/s3blob"
+)
+
+type Reader interface {
+	io.ReadSeekCloser
+	Size() int64
+	ModTime() time.Time
+}
+
+type Attributes struct {
+	Size    int64
+	ModTime time.Time
+	MD5     []byte
+}
+
+// Storage holds published tarballs, addressed by the same keys PocketBase
+// uses for uploaded files.
+type Storage interface {
+	Open(key string) (Reader, error)
+	Attributes(key string) (*Attributes, error)
+	Write(key string, content []byte) error
+	Delete(key string) error
+	Close() error
+}
+
+type bucket struct {
+	ctx    context.Context
+	bucket *gblob.Bucket
+}
+
+func NewLocal(dir string) (Storage, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	b, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bucket{ctx: context.Background(), bucket: b}, nil
+}
+
+func NewS3(bucketName string, region string, endpoint string, accessKey string, secretKey string, forcePathStyle bool) (Storage, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String(region),
+		Endpoint:         aws.String(endpoint),
+		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
+		S3ForcePathStyle: aws.Bool(forcePathStyle),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := s3blob.OpenBucket(context.Background(), sess, bucketName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bucket{ctx: context.Background(), bucket: b}, nil
+}
+
+func NewMemory() Storage {
+	return &bucket{ctx: context.Background(), bucket: memblob.OpenBucket(nil)}
+}
+
+func (b *bucket) Open(key string) (Reader, error) {
+	return b.bucket.NewReader(b.ctx, key, nil)
+}
+
+func (b *bucket) Attributes(key string) (*Attributes, error) {
+	attributes, err := b.bucket.Attributes(b.ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Attributes{Size: attributes.Size, ModTime: attributes.ModTime, MD5: attributes.MD5}, nil
+}
+
+func (b *bucket) Write(key string, content []byte) error {
+	return b.bucket.WriteAll(b.ctx, key, content, nil)
+}
+
+func (b *bucket) Delete(key string) error {
+	return b.bucket.Delete(b.ctx, key)
+}
+
+func (b *bucket) Close() error {
+	return b.bucket.Close()
+}
+
+var overrideLock sync.RWMutex
+var override Storage
+
+// Use makes For return storage instead of the backend configured in the
+// app settings, for running against a stub or an in-memory fake. Passing
+// nil restores the configured backend.
+func Use(storage Storage) {
+	overrideLock.Lock()
+	defer overrideLock.Unlock()
+
+	override = storage
+}
+
+type shared struct {
+	Storage
+}
+
+func (shared) Close() error {
+	return nil
+}
+
+type config struct {
+	s3  settings.S3Config
+	dir string
+}
+
+var openLock sync.Mutex
+var opened Storage
+var openedConfig config
+
+// For returns the storage the app uploads files to: S3 when it is enabled
+// in the settings and the local storage directory otherwise. The storage
+// is opened once and reused until the settings change. The result has to
+// be closed.
+func For(app core.App) (Storage, error) {
+	overrideLock.RLock()
+	defer overrideLock.RUnlock()
+
+	if override != nil {
+		return shared{override}, nil
+	}
+
+	current := config{dir: filepath.Join(app.DataDir(), "storage")}
+	if s3 := app.Settings().S3; s3.Enabled {
+		current = config{s3: s3}
+	}
+
+	openLock.Lock()
+	defer openLock.Unlock()
+
+	if opened != nil && openedConfig == current {
+		return shared{opened}, nil
+	}
+
+	var storage Storage
+	var err error
+	if current.s3.Enabled {
+		storage, err = NewS3(current.s3.Bucket, current.s3.Region, current.s3.Endpoint, current.s3.AccessKey, current.s3.Secret, current.s3.ForcePathStyle)
+	} else {
+		storage, err = NewLocal(current.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opened != nil {
+		opened.Close()
+	}
+	opened, openedConfig = storage, current
+
+	return shared{opened}, nil
+}
+
+// File is a single stored file, opened on demand.
+type File struct {
+	app core.App
+	Key string
+}
+
+// Tarball returns the tarball of a version record.
+func Tarball(app core.App, record *models.Record) File {
+	return File{app: app, Key: record.BaseFilesPath() + "/" + record.GetString("tarball")}
+}
+
+// Build returns the cached output of building name from the tarball of a
+// version record. Keeping it next to the tarball removes it together with
+// the record.
+func Build(app core.App, record *models.Record, variant string, name string) File {
+	return File{app: app, Key: record.BaseFilesPath() + "/builds/" + variant + "/" + name}
+}
+
+type fileReader struct {
+	Reader
+	storage Storage
+}
+
+func (r *fileReader) Close() error {
+	err := r.Reader.Close()
+	r.storage.Close()
+
+	return err
+}
+
+// Open streams the file. Closing the reader also releases the storage.
+func (f File) Open() (Reader, error) {
+	storage, err := For(f.app)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := storage.Open(f.Key)
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+
+	return &fileReader{Reader: reader, storage: storage}, nil
+}
+
+func (f File) Read() ([]byte, error) {
+	reader, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func (f File) Write(content []byte) error {
+	storage, err := For(f.app)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	return storage.Write(f.Key, content)
+}
+
+func (f File) Attributes() (*Attributes, error) {
+	storage, err := For(f.app)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+
+	return storage.Attributes(f.Key)
+}
+
+// Serve writes the file as a download named name, with support for range
+// and conditional requests.
+func (f File) Serve(w http.ResponseWriter, r *http.Request, name string) error {
+	reader, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Disposition", "attachment; filename="+name)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+
+	http.ServeContent(w, r, name, reader.ModTime(), reader)
+	return nil
+}
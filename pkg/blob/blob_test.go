@@ -0,0 +1,95 @@
+package blob
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFileServe(t *testing.T) {
+	storage := NewMemory()
+	Use(storage)
+	defer Use(nil)
+
+	if err := storage.Write("versions/abc/pkg.tgz", []byte("tarball contents")); err != nil {
+		t.Fatal(err)
+	}
+
+	file := File{Key: "versions/abc/pkg.tgz"}
+
+	rec := httptest.NewRecorder()
+	if err := file.Serve(rec, httptest.NewRequest(http.MethodGet, "/pkg/_/pkg.tgz", nil), "pkg-1.0.0.tgz"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/gzip" {
+		t.Errorf("Content-Type = %q, want application/gzip", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != "attachment; filename=pkg-1.0.0.tgz" {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if got := rec.Body.String(); got != "tarball contents" {
+		t.Errorf("body = %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/pkg/_/pkg.tgz", nil)
+	req.Header.Set("Range", "bytes=0-6")
+
+	rec = httptest.NewRecorder()
+	if err := file.Serve(rec, req, "pkg-1.0.0.tgz"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("range status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if got := rec.Body.String(); got != "tarball" {
+		t.Errorf("range body = %q, want %q", got, "tarball")
+	}
+}
+
+func TestFileServeMissing(t *testing.T) {
+	Use(NewMemory())
+	defer Use(nil)
+
+	rec := httptest.NewRecorder()
+	if err := (File{Key: "missing.tgz"}).Serve(rec, httptest.NewRequest(http.MethodGet, "/", nil), "missing.tgz"); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestFileReadWrite(t *testing.T) {
+	Use(NewMemory())
+	defer Use(nil)
+
+	file := File{Key: "versions/abc/builds/es2022/index.js"}
+	if err := file.Write([]byte("export {}")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "export {}" || reader.Size() != int64(len(content)) {
+		t.Errorf("read %q with size %d", content, reader.Size())
+	}
+
+	attributes, err := file.Attributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attributes.Size != int64(len(content)) {
+		t.Errorf("attributes size = %d, want %d", attributes.Size, len(content))
+	}
+}
@@ -0,0 +1,319 @@
+package builds
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"registry/pkg/just"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const buildsCollection = "just_builds"
+
+const StatusOK = "ok"
+const StatusFailed = "failed"
+const StatusWarning = "warning"
+
+var targets = map[string]api.Target{
+	"es2022": api.ES2022,
+	"es2021": api.ES2021,
+	"es2020": api.ES2020,
+	"es2019": api.ES2019,
+	"es2018": api.ES2018,
+	"es2017": api.ES2017,
+	"es2016": api.ES2016,
+	"es2015": api.ES2015,
+	"es6":    api.ES2015,
+}
+
+var loader = map[string]api.Loader{
+	".wasm":  api.LoaderDataURL,
+	".svg":   api.LoaderDataURL,
+	".png":   api.LoaderDataURL,
+	".webp":  api.LoaderDataURL,
+	".ttf":   api.LoaderDataURL,
+	".eot":   api.LoaderDataURL,
+	".woff":  api.LoaderDataURL,
+	".woff2": api.LoaderDataURL,
+}
+
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Text     string `json:"text"`
+	LineText string `json:"lineText,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	if d.File == "" {
+		return d.Text
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Text)
+}
+
+type Result struct {
+	Output   []byte
+	Errors   []Diagnostic
+	Warnings []Diagnostic
+}
+
+func (r *Result) Failed() bool {
+	return len(r.Errors) > 0
+}
+
+// Error joins the errors of a failed build, one per line.
+func (r *Result) Error() string {
+	lines := []string{}
+	for _, diagnostic := range r.Errors {
+		lines = append(lines, diagnostic.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Report is the recorded outcome of a build that failed or had warnings.
+// Target holds the variant that was built, like es2022-nominify.
+type Report struct {
+	Package  string         `json:"package"`
+	Version  string         `json:"version"`
+	File     string         `json:"file"`
+	Target   string         `json:"target"`
+	Status   string         `json:"status"`
+	Errors   []Diagnostic   `json:"errors"`
+	Warnings []Diagnostic   `json:"warnings"`
+	Updated  types.DateTime `json:"updated"`
+}
+
+// Banner is the comment every built file starts with.
+func Banner(packageName string, version string, target string) string {
+	return fmt.Sprintf("/* r.justjs.dev - esbuild bundle(%s@%s) %s production */", packageName, version, target)
+}
+
+// Variant names the output of a build for target with the options of
+// runtime. Runtimes with the default options share the plain target name.
+func Variant(target string, runtime just.Runtime) string {
+	variant := target
+	if !runtime.ShouldMinify() {
+		variant += "-nominify"
+	}
+	if !runtime.ShouldKeepNames() {
+		variant += "-nokeepnames"
+	}
+
+	return variant
+}
+
+// Target returns the newest target runtime allows, or an empty string when
+// none of its targets is known.
+func Target(runtime just.Runtime) string {
+	for _, target := range []string{"es2022", "es2021", "es2020", "es2019", "es2018", "es2017", "es2016", "es2015", "es6"} {
+		if runtime.AllowsTarget(target) {
+			return target
+		}
+	}
+
+	return ""
+}
+
+func IsTarget(target string) bool {
+	_, ok := targets[target]
+	return ok
+}
+
+// Transform builds a single file of a package as an ES module for target.
+func Transform(name string, contents []byte, target string, runtime just.Runtime, banner string) *Result {
+	esTarget, ok := targets[target]
+	if !ok {
+		return &Result{Errors: []Diagnostic{{Text: fmt.Sprintf("unknown target %s", target)}}}
+	}
+
+	build := api.Build(api.BuildOptions{
+		Loader: loader,
+		Stdin: &api.StdinOptions{
+			Contents:   string(contents),
+			Sourcefile: name,
+		},
+		EntryPoints:       nil,
+		MinifyWhitespace:  runtime.ShouldMinify(),
+		MinifyIdentifiers: runtime.ShouldMinify(),
+		MinifySyntax:      runtime.ShouldMinify(),
+		KeepNames:         runtime.ShouldKeepNames(),
+		Write:             false,
+		Bundle:            false,
+		Banner:            map[string]string{"js": banner},
+		Target:            esTarget,
+		Format:            api.FormatESModule,
+		LogLevel:          api.LogLevelSilent,
+		Platform:          api.PlatformBrowser,
+	})
+
+	result := &Result{Errors: diagnostics(build.Errors), Warnings: diagnostics(build.Warnings)}
+	if len(build.OutputFiles) > 0 {
+		result.Output = build.OutputFiles[0].Contents
+	} else if len(result.Errors) == 0 {
+		result.Errors = []Diagnostic{{File: name, Text: "build produced no output"}}
+	}
+
+	return result
+}
+
+func diagnostics(messages []api.Message) []Diagnostic {
+	out := []Diagnostic{}
+	for _, message := range messages {
+		diagnostic := Diagnostic{Text: message.Text}
+		if message.Location != nil {
+			diagnostic.File = message.Location.File
+			diagnostic.Line = message.Location.Line
+			diagnostic.Column = message.Location.Column
+			diagnostic.LineText = message.Location.LineText
+		}
+
+		out = append(out, diagnostic)
+	}
+
+	return out
+}
+
+// EnsureReports creates the collection build reports are recorded in.
+func EnsureReports(dao *daos.Dao) error {
+	if exists, _ := dao.FindCollectionByNameOrId(buildsCollection); exists != nil {
+		return nil
+	}
+
+	collection := &models.Collection{
+		Name: buildsCollection,
+		Type: models.CollectionTypeBase,
+		Schema: schema.NewSchema(
+			&schema.SchemaField{Name: "package", Type: schema.FieldTypeText, Required: true},
+			&schema.SchemaField{Name: "version", Type: schema.FieldTypeText, Required: true},
+			&schema.SchemaField{Name: "file", Type: schema.FieldTypeText, Required: true},
+			&schema.SchemaField{Name: "target", Type: schema.FieldTypeText, Required: true},
+			&schema.SchemaField{Name: "status", Type: schema.FieldTypeText, Required: true},
+			&schema.SchemaField{Name: "errors", Type: schema.FieldTypeJson},
+			&schema.SchemaField{Name: "warnings", Type: schema.FieldTypeJson},
+		),
+	}
+
+	if err := dao.SaveCollection(collection); err != nil {
+		return err
+	}
+
+	_, err := dao.DB().NewQuery(
+		"CREATE UNIQUE INDEX IF NOT EXISTS [[idx_just_builds_package_version_file_target]] ON {{just_builds}} ([[package]], [[version]], [[file]], [[target]])",
+	).Execute()
+
+	return err
+}
+
+// Record keeps the diagnostics of a build so maintainers can see which of
+// their files fail to transform. Clean builds drop the report of an earlier
+// build instead. Reports are kept per variant, as runtimes sharing a target
+// may still build it with different options.
+func Record(app core.App, packageName string, version string, file string, variant string, result *Result) error {
+	collection, err := app.Dao().FindCollectionByNameOrId(buildsCollection)
+	if err != nil {
+		return err
+	}
+
+	records, err := app.Dao().FindRecordsByExpr(buildsCollection, dbx.HashExp{
+		"package": packageName,
+		"version": version,
+		"file":    file,
+		"target":  variant,
+	})
+	if err != nil {
+		return err
+	}
+
+	status := StatusFailed
+	if !result.Failed() {
+		if len(result.Warnings) == 0 {
+			for _, record := range records {
+				if err := app.Dao().DeleteRecord(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		status = StatusWarning
+	}
+
+	errorsJSON, err := json.Marshal(result.Errors)
+	if err != nil {
+		return err
+	}
+
+	warningsJSON, err := json.Marshal(result.Warnings)
+	if err != nil {
+		return err
+	}
+
+	record := models.NewRecord(collection)
+	if len(records) > 0 {
+		record = records[0]
+
+		// builds of a version are deterministic, so the same outcome is
+		// usually recorded already
+		if record.GetString("status") == status && record.GetString("errors") == string(errorsJSON) && record.GetString("warnings") == string(warningsJSON) {
+			return nil
+		}
+	}
+
+	record.Set("package", packageName)
+	record.Set("version", version)
+	record.Set("file", file)
+	record.Set("target", variant)
+	record.Set("status", status)
+	record.Set("errors", string(errorsJSON))
+	record.Set("warnings", string(warningsJSON))
+
+	return app.Dao().SaveRecord(record)
+}
+
+// Reports lists the recorded builds of a package, optionally only for one
+// version.
+func Reports(app core.App, packageName string, version string) ([]*Report, error) {
+	exprs := []dbx.Expression{dbx.HashExp{"package": packageName}}
+	if version != "" {
+		exprs = append(exprs, dbx.HashExp{"version": version})
+	}
+
+	records, err := app.Dao().FindRecordsByExpr(buildsCollection, exprs...)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []*Report{}
+	for _, record := range records {
+		report := &Report{
+			Package: record.GetString("package"),
+			Version: record.GetString("version"),
+			File:    record.GetString("file"),
+			Target:  record.GetString("target"),
+			Status:  record.GetString("status"),
+			Updated: record.Updated,
+		}
+
+		if err := json.Unmarshal([]byte(record.GetString("errors")), &report.Errors); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(record.GetString("warnings")), &report.Warnings); err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
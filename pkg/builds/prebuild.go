@@ -0,0 +1,262 @@
+package builds
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+
+	"registry/pkg/blob"
+	"registry/pkg/helpers"
+	"registry/pkg/just"
+	"registry/pkg/store"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/spf13/cobra"
+)
+
+const StatePending = "pending"
+const StateDone = "done"
+const StateFailed = "failed"
+
+// at most maxPrebuildFiles modules of a version are built ahead of time,
+// the rest is built on first request
+const maxPrebuildFiles = 500
+const queueSize = 1024
+
+var modules = map[string]bool{".js": true, ".mjs": true, ".cjs": true}
+
+// Status is stored on a version: the state of its prebuild and the outcome
+// for every file and variant. Skipped counts the modules left over once
+// maxPrebuildFiles were built.
+type Status struct {
+	State   string                       `json:"state"`
+	Files   map[string]map[string]string `json:"files,omitempty"`
+	Skipped int                          `json:"skipped,omitempty"`
+}
+
+type prebuilder struct {
+	app     core.App
+	workers int
+	queue   chan string
+}
+
+var active = &prebuilder{}
+
+func Register(app core.App, rootCmd *cobra.Command) {
+	active.app = app
+
+	rootCmd.PersistentFlags().IntVar(
+		&active.workers,
+		"prebuildWorkers",
+		2,
+		"number of workers building the modules of new versions for every runtime target (0 disables)",
+	)
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if active.workers > 0 {
+			active.queue = make(chan string, queueSize)
+			for i := 0; i < active.workers; i++ {
+				go active.worker()
+			}
+
+			go active.resume()
+		}
+
+		return nil
+	})
+}
+
+// Prebuild marks a version as pending and queues it for the workers. When
+// the server isn't running, or the queue is full, the version is picked up
+// on the next start instead.
+func Prebuild(app core.App, record *models.Record) error {
+	if active.workers <= 0 {
+		return nil
+	}
+
+	if err := saveStatus(app, record, Status{State: StatePending}); err != nil {
+		return err
+	}
+
+	if active.queue != nil {
+		select {
+		case active.queue <- record.Id:
+		default:
+			log.Printf("builds: prebuild queue is full, '%s' waits for the next start", record.Id)
+		}
+	}
+
+	return nil
+}
+
+// resume queues the versions whose prebuild was interrupted.
+func (p *prebuilder) resume() {
+	records, err := p.app.Dao().FindRecordsByExpr(store.VersionsCollection, dbx.Like("builds", `"state":"`+StatePending+`"`))
+	if err != nil {
+		log.Printf("builds: unable to find pending prebuilds: %v", err)
+		return
+	}
+
+	for _, record := range records {
+		p.queue <- record.Id
+	}
+}
+
+func (p *prebuilder) worker() {
+	for id := range p.queue {
+		record, err := p.app.Dao().FindRecordById(store.VersionsCollection, id)
+		if err != nil {
+			continue
+		}
+
+		if err := p.build(record); err != nil {
+			log.Printf("builds: prebuild of '%s' failed: %v", id, err)
+
+			// failed versions are not resumed, their files are still built
+			// on request
+			if err := saveStatus(p.app, record, Status{State: StateFailed}); err != nil {
+				log.Printf("builds: unable to save prebuild state of '%s': %v", id, err)
+			}
+		}
+	}
+}
+
+func (p *prebuilder) build(record *models.Record) error {
+	pkg, err := p.app.Dao().FindRecordById(store.PackagesCollection, record.GetString("package"))
+	if err != nil {
+		return err
+	}
+
+	tarball := blob.Tarball(p.app, record)
+	entries, err := helpers.ListTar(tarball)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	skipped := 0
+	for _, entry := range entries {
+		if !modules[path.Ext(entry.Path)] {
+			continue
+		}
+
+		if len(names) < maxPrebuildFiles {
+			names = append(names, entry.Path)
+		} else {
+			skipped++
+		}
+	}
+
+	files, err := helpers.ReadFilesFromTar(tarball, names)
+	if err != nil {
+		return err
+	}
+
+	packageName, version := pkg.GetString("name"), record.GetString("version")
+	status := Status{State: StateDone, Files: map[string]map[string]string{}, Skipped: skipped}
+	wanted := variants()
+
+	for _, name := range names {
+		status.Files[name] = map[string]string{}
+
+		for variant, options := range wanted {
+			if _, err := Cached(p.app, record, name, variant); err == nil {
+				status.Files[name][variant] = StatusOK
+				continue
+			}
+
+			result := Transform(name, files[name], options.target, options.runtime, Banner(packageName, version, options.target))
+			if err := Record(p.app, packageName, version, name, variant, result); err != nil {
+				log.Printf("builds: unable to record build of %s@%s/%s: %v", packageName, version, name, err)
+			}
+
+			switch {
+			case result.Failed():
+				status.Files[name][variant] = StatusFailed
+				continue
+			case len(result.Warnings) > 0:
+				status.Files[name][variant] = StatusWarning
+			default:
+				status.Files[name][variant] = StatusOK
+			}
+
+			if err := Store(p.app, record, name, variant, result.Output); err != nil {
+				return err
+			}
+		}
+	}
+
+	return saveStatus(p.app, record, status)
+}
+
+// saveStatus writes only the builds column of a version, the record of a
+// running prebuild may be stale by the time it finishes and saving all of
+// it would undo changes made in the meantime.
+func saveStatus(app core.App, record *models.Record, status Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.Dao().DB().Update(store.VersionsCollection, dbx.Params{"builds": string(data)}, dbx.HashExp{"id": record.Id}).Execute()
+	if err != nil {
+		return err
+	}
+
+	record.Set("builds", status)
+	return nil
+}
+
+type variantOptions struct {
+	target  string
+	runtime just.Runtime
+}
+
+// variants returns every build variant the configured runtimes can ask
+// for.
+func variants() map[string]variantOptions {
+	all := []string{}
+	for target := range targets {
+		all = append(all, target)
+	}
+	sort.Strings(all)
+
+	variants := map[string]variantOptions{}
+	for _, version := range just.Runtimes() {
+		runtime, ok := just.Lookup(version)
+		if !ok {
+			continue
+		}
+
+		for _, target := range all {
+			if runtime.AllowsTarget(target) {
+				variants[Variant(target, runtime)] = variantOptions{target: target, runtime: runtime}
+			}
+		}
+	}
+
+	return variants
+}
+
+// Cached returns the stored output of building file of a version for
+// variant.
+func Cached(app core.App, record *models.Record, file string, variant string) ([]byte, error) {
+	if !fs.ValidPath(file) {
+		return nil, &fs.PathError{Op: "open", Path: file, Err: fs.ErrInvalid}
+	}
+
+	return blob.Build(app, record, variant, file).Read()
+}
+
+// Store keeps the output of a successful build for later requests.
+func Store(app core.App, record *models.Record, file string, variant string, output []byte) error {
+	if !fs.ValidPath(file) {
+		return &fs.PathError{Op: "write", Path: file, Err: fs.ErrInvalid}
+	}
+
+	return blob.Build(app, record, variant, file).Write(output)
+}
@@ -1,182 +1,157 @@
 package create
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"strings"
 
+	"registry/pkg/auth"
+	"registry/pkg/blob"
+	"registry/pkg/builds"
+	"registry/pkg/helpers"
 	"registry/pkg/parse"
-   "golang.org/x/exp/slices"
+	"registry/pkg/search"
+	"registry/pkg/store"
+	"registry/pkg/templates"
 
 	"github.com/labstack/echo/v5"
-	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/forms"
 	"github.com/pocketbase/pocketbase/models"
-	"github.com/pocketbase/pocketbase/models/schema"
-	"github.com/pocketbase/pocketbase/tools/types"
+	"golang.org/x/exp/slices"
 )
 
-func Package(app core.App, c echo.Context) error {
-	package_name, err := parse.EncodeName(c.FormValue("name"))
-	if err != nil {
-		return err
-	}
-
-	exists, _ := app.Dao().FindCollectionByNameOrId(package_name)
-	auth, err := app.Dao().FindCollectionByNameOrId("just_auth_system")
+// Publish saves a new version of a package. A package is created with its
+// first version, later versions update its metadata once they are saved.
+func Publish(app core.App, c echo.Context) error {
+	created, err := Package(app, c)
 	if err != nil {
 		return err
 	}
 
-	if exists != nil {
-		return nil
-	} else {
-		collection := &models.Collection{}
-		form := forms.NewCollectionUpsert(app, collection)
-		form.Name = package_name
-		form.Type = models.CollectionTypeBase
-		form.ListRule = types.Pointer("@request.auth.id = access.id")
-		form.ViewRule = types.Pointer("@request.auth.id = access.id")
-		form.CreateRule = nil
-		form.UpdateRule = nil
-		form.DeleteRule = nil
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "access",
-			Type:     schema.FieldTypeRelation,
-			Required: true,
-			Unique:   false,
-			Options: &schema.RelationOptions{
-				CollectionId:  auth.Id,
-				CascadeDelete: false,
-			},
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "visibility",
-			Type:     schema.FieldTypeSelect,
-			Required: true,
-			Unique:   false,
-			Options: &schema.SelectOptions{
-				MaxSelect: 1,
-				Values:    []string{"public", "private"},
-			},
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "group",
-			Type:     schema.FieldTypeSelect,
-			Required: true,
-			Unique:   false,
-			Options: &schema.SelectOptions{
-				MaxSelect: 1,
-				Values:    []string{"local", "net", "both"},
-			},
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "description",
-			Type:     schema.FieldTypeText,
-			Required: false,
-			Unique:   false,
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "index",
-			Type:     schema.FieldTypeText,
-			Required: true,
-			Unique:   false,
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "author",
-			Type:     schema.FieldTypeText,
-			Required: true,
-			Unique:   false,
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "url",
-			Type:     schema.FieldTypeText,
-			Required: false,
-			Unique:   false,
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "repository",
-			Type:     schema.FieldTypeText,
-			Required: false,
-			Unique:   false,
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "license",
-			Type:     schema.FieldTypeText,
-			Required: false,
-			Unique:   false,
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "dependencies",
-			Type:     schema.FieldTypeJson,
-			Required: false,
-			Unique:   false,
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "version",
-			Type:     schema.FieldTypeText,
-			Required: true,
-			Unique:   true,
-			Options: &schema.TextOptions{
-				Pattern: `^(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-((?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`,
-			},
-		})
-
-		form.Schema.AddField(&schema.SchemaField{
-			Name:     "tarball",
-			Type:     schema.FieldTypeFile,
-			Required: true,
-			Unique:   false,
-			Options: &schema.FileOptions{
-				MaxSelect: 1,
-				MaxSize:   10485760,
-				MimeTypes: []string{"application/gzip"},
-			},
-		})
-
-		if err := form.Submit(); err != nil {
-			return err
+	if err := Version(app, c); err != nil {
+		// don't leave a package without versions behind
+		if created != nil {
+			if versions, _ := store.Versions(app, created); len(versions) == 0 {
+				if err := app.Dao().DeleteRecord(created); err != nil {
+					log.Printf("create: unable to remove '%s' after a failed publish: %v", created.GetString("name"), err)
+				}
+			}
 		}
+		return err
 	}
 
 	return nil
 }
 
-func CheckAuth(app core.App, c echo.Context, package_name string) bool {
-	exists, _ := app.Dao().FindCollectionByNameOrId(package_name)
-	if exists == nil {
-		return true
+// Package creates the metadata record of a package on its first publish and
+// returns it. Existing packages are left alone and nil is returned.
+func Package(app core.App, c echo.Context) (*models.Record, error) {
+	name := c.FormValue("name")
+	package_name, err := parse.EncodeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if CheckAuth(app, c, package_name) == false {
+		return nil, errors.New(fmt.Sprintf("You do not have permission to publish '%s'. Are you logged in as the correct user?", name))
+	}
+
+	if err := CheckType(app, package_name, c.FormValue("type")); err != nil {
+		return nil, err
+	}
+
+	if _, err := store.EnsureVersions(app.Dao()); err != nil {
+		return nil, err
+	}
+
+	if _, err := store.FindPackage(app, name); err == nil {
+		return nil, nil
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
 	}
 
-	records, err := app.Dao().FindRecordsByExpr(package_name, dbx.HashExp{"visibility": "public"})
+	data, err := packageData(c)
 	if err != nil {
-		return false
+		return nil, err
+	}
+
+	return store.SavePackage(app, name, data)
+}
+
+// packageData reads the metadata of a package from a publish request.
+func packageData(c echo.Context) (map[string]any, error) {
+	params, err := c.FormValues()
+	if err != nil {
+		return nil, err
+	}
+
+	access := []string{}
+	if user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record); user != nil {
+		access = append(access, user.Id)
+	}
+	for _, id := range params["access"] {
+		if !slices.Contains(access, id) {
+			access = append(access, id)
+		}
+	}
+
+	data := map[string]any{"access": access}
+	for _, field := range store.Fields {
+		if field != "access" && field != "keywords" {
+			data[field] = params.Get(field)
+		}
+	}
+
+	if keywords := Keywords(params["keywords"]); len(keywords) > 0 {
+		data["keywords"] = keywords
+	}
+
+	return data, nil
+}
+
+func Keywords(values []string) []string {
+	keywords := []string{}
+
+	for _, value := range values {
+		parts := []string{}
+		if err := json.Unmarshal([]byte(value), &parts); err != nil {
+			parts = strings.Split(value, ",")
+		}
+
+		for _, part := range parts {
+			keyword := strings.ToLower(strings.TrimSpace(part))
+			if keyword != "" && !slices.Contains(keywords, keyword) {
+				keywords = append(keywords, keyword)
+			}
+		}
 	}
 
-	admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin)
-	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	return keywords
+}
+
+func CheckAuth(app core.App, c echo.Context, package_name string) bool {
+	return auth.CanPublish(app, c, parse.OriginalName(package_name))
+}
+
+func CheckType(app core.App, package_name string, package_type string) error {
+	if package_type == "" {
+		package_type = "package"
+	}
 
-	if admin != nil {
-		return true
+	pkg, err := store.FindPackage(app, parse.OriginalName(package_name))
+	if err != nil {
+		return nil
 	}
 
-	if len(records) == 0 {
-		return true
+	if current := helpers.PackageType(pkg); current != package_type {
+		return errors.New(fmt.Sprintf("'%s' is published as a %s and cannot be published as a %s", parse.OriginalName(package_name), current, package_type))
 	}
 
-	return slices.Contains(records[len(records)-1].GetStringSlice("access"), user.Id)
+	return nil
 }
 
 func Version(app core.App, c echo.Context) error {
@@ -189,21 +164,66 @@ func Version(app core.App, c echo.Context) error {
 		return errors.New(fmt.Sprintf("You do not have permission to publish '%s'. Are you logged in as the correct user?", c.FormValue("name")))
 	}
 
-	collection, err := app.Dao().FindCollectionByNameOrId(package_name)
+	collection, err := store.EnsureVersions(app.Dao())
+	if err != nil {
+		return err
+	}
+
+	pkg, err := store.FindPackage(app, c.FormValue("name"))
 	if err != nil {
 		return err
 	}
 
+	if _, err := store.FindVersion(app, pkg, c.FormValue("version")); err == nil {
+		return errors.New(fmt.Sprintf("'%s@%s' has already been published", c.FormValue("name"), c.FormValue("version")))
+	}
+
 	record := models.NewRecord(collection)
 	form := forms.NewRecordUpsert(app, record)
 
 	if err := form.LoadRequest(c.Request(), ""); err != nil {
 		return err
 	}
+	form.Data()["package"] = pkg.Id
 
 	if err := form.Submit(); err != nil {
 		return err
 	}
 
+	readme, changelog := helpers.Documents(blob.Tarball(app, record))
+	if err := store.SaveDocuments(app.Dao(), record, readme, changelog); err != nil {
+		return err
+	}
+
+	if helpers.PackageType(pkg) == "template" {
+		archive, err := templates.NewArchive(blob.Tarball(app, record), pkg.GetString("name"))
+		if err == nil {
+			err = templates.SaveArchive(app.Dao(), record, archive)
+		}
+		if err != nil {
+			log.Printf("templates: failed to archive '%s@%s': %v", pkg.GetString("name"), record.GetString("version"), err)
+		}
+	}
+
+	// the visibility of a package is chosen when it is created, publishing
+	// a version only updates the descriptive metadata. Saving the package
+	// also puts the new version in the search index.
+	data, err := packageData(c)
+	if err == nil {
+		delete(data, "visibility")
+		_, err = store.SavePackage(app, pkg.GetString("name"), data)
+	}
+	if err != nil {
+		log.Printf("create: failed to update the metadata of '%s': %v", pkg.GetString("name"), err)
+
+		if err := search.Index(app, pkg); err != nil {
+			log.Printf("search: failed to index '%s': %v", pkg.GetString("name"), err)
+		}
+	}
+
+	if err := builds.Prebuild(app, record); err != nil {
+		log.Printf("builds: failed to queue prebuild of '%s@%s': %v", pkg.GetString("name"), record.GetString("version"), err)
+	}
+
 	return nil
 }
@@ -0,0 +1,248 @@
+package diff
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"registry/pkg/blob"
+	"registry/pkg/helpers"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	utildiff "github.com/go-git/go-git/v5/utils/diff"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// files larger than maxFile are listed without a patch, and patches stop
+// being generated once the response holds maxTotal bytes of them
+const maxFile = 512 * 1024
+const maxTotal = 4 * 1024 * 1024
+const contextLines = 3
+const timeout = 2 * time.Second
+
+type File struct {
+	Path      string `json:"path"`
+	Status    string `json:"status"`
+	OldSize   int64  `json:"oldSize"`
+	NewSize   int64  `json:"newSize"`
+	Binary    bool   `json:"binary"`
+	Truncated bool   `json:"truncated"`
+	Patch     string `json:"patch,omitempty"`
+}
+
+type Result struct {
+	Package   string `json:"package"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Files     []File `json:"files"`
+	Truncated bool   `json:"truncated"`
+}
+
+// Versions compares the tarballs of two versions of a package and returns
+// the added, removed and modified files with unified diffs for text files.
+func Versions(app core.App, pkg *models.Record, from *models.Record, to *models.Record) (*Result, error) {
+	fromPath, toPath := blob.Tarball(app, from), blob.Tarball(app, to)
+
+	fromEntries, err := entries(fromPath)
+	if err != nil {
+		return nil, err
+	}
+
+	toEntries, err := entries(toPath)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for path := range fromEntries {
+		paths = append(paths, path)
+	}
+	for path := range toEntries {
+		if _, ok := fromEntries[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	files := []File{}
+	fromNames, toNames := []string{}, []string{}
+
+	for _, path := range paths {
+		old, hasOld := fromEntries[path]
+		current, hasCurrent := toEntries[path]
+
+		file := File{Path: path}
+		switch {
+		case !hasOld:
+			file.Status = "added"
+		case !hasCurrent:
+			file.Status = "removed"
+		case old.SHA256 != current.SHA256 || old.Mode != current.Mode:
+			file.Status = "modified"
+		default:
+			continue
+		}
+
+		file.OldSize, file.NewSize = old.Size, current.Size
+		if file.OldSize > maxFile || file.NewSize > maxFile {
+			file.Truncated = true
+		} else {
+			if hasOld {
+				fromNames = append(fromNames, path)
+			}
+			if hasCurrent {
+				toNames = append(toNames, path)
+			}
+		}
+
+		files = append(files, file)
+	}
+
+	fromFiles, err := helpers.ReadFilesFromTar(fromPath, fromNames)
+	if err != nil {
+		return nil, err
+	}
+
+	toFiles, err := helpers.ReadFilesFromTar(toPath, toNames)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Package: pkg.GetString("name"),
+		From:    from.GetString("version"),
+		To:      to.GetString("version"),
+		Files:   files,
+	}
+	total := 0
+
+	for i := range files {
+		file := &files[i]
+		if file.Truncated {
+			continue
+		}
+
+		old, current := fromFiles[file.Path], toFiles[file.Path]
+		if isBinary(old) || isBinary(current) {
+			file.Binary = true
+			continue
+		}
+
+		if total >= maxTotal {
+			file.Truncated = true
+			result.Truncated = true
+			continue
+		}
+
+		patch, err := unified(file.Path, fromEntries[file.Path], old, toEntries[file.Path], current)
+		if err != nil {
+			return nil, err
+		}
+
+		file.Patch = patch
+		total += len(patch)
+	}
+
+	return result, nil
+}
+
+func entries(source blob.File) (map[string]helpers.TarEntry, error) {
+	list, err := helpers.ListTar(source)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]helpers.TarEntry{}
+	for _, entry := range list {
+		result[entry.Path] = entry
+	}
+
+	return result, nil
+}
+
+func isBinary(content []byte) bool {
+	sample := content
+	if len(sample) > 8000 {
+		sample = sample[:8000]
+	}
+
+	return bytes.IndexByte(sample, 0) >= 0 || !utf8.Valid(content)
+}
+
+func unified(path string, oldEntry helpers.TarEntry, old []byte, currentEntry helpers.TarEntry, current []byte) (string, error) {
+	var from, to fdiff.File
+	if oldEntry.Path != "" {
+		from = newFile(path, oldEntry, old)
+	}
+	if currentEntry.Path != "" {
+		to = newFile(path, currentEntry, current)
+	}
+
+	chunks := []fdiff.Chunk{}
+	for _, d := range utildiff.DoWithTimeout(string(old), string(current), timeout) {
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			chunks = append(chunks, chunk{d.Text, fdiff.Equal})
+		case diffmatchpatch.DiffInsert:
+			chunks = append(chunks, chunk{d.Text, fdiff.Add})
+		case diffmatchpatch.DiffDelete:
+			chunks = append(chunks, chunk{d.Text, fdiff.Delete})
+		}
+	}
+
+	var out strings.Builder
+	err := fdiff.NewUnifiedEncoder(&out, contextLines).Encode(patch{[]fdiff.FilePatch{filePatch{from, to, chunks}}})
+
+	return out.String(), err
+}
+
+func newFile(path string, entry helpers.TarEntry, content []byte) file {
+	mode := filemode.Regular
+	if perm, err := strconv.ParseUint(entry.Mode, 8, 32); err == nil && perm&0111 != 0 {
+		mode = filemode.Executable
+	}
+
+	return file{path, plumbing.ComputeHash(plumbing.BlobObject, content), mode}
+}
+
+type patch struct {
+	files []fdiff.FilePatch
+}
+
+func (p patch) FilePatches() []fdiff.FilePatch { return p.files }
+func (p patch) Message() string                { return "" }
+
+type filePatch struct {
+	from   fdiff.File
+	to     fdiff.File
+	chunks []fdiff.Chunk
+}
+
+func (p filePatch) IsBinary() bool                  { return false }
+func (p filePatch) Files() (fdiff.File, fdiff.File) { return p.from, p.to }
+func (p filePatch) Chunks() []fdiff.Chunk           { return p.chunks }
+
+type file struct {
+	path string
+	hash plumbing.Hash
+	mode filemode.FileMode
+}
+
+func (f file) Hash() plumbing.Hash     { return f.hash }
+func (f file) Mode() filemode.FileMode { return f.mode }
+func (f file) Path() string            { return f.path }
+
+type chunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c chunk) Content() string       { return c.content }
+func (c chunk) Type() fdiff.Operation { return c.op }
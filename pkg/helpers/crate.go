@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type response struct {
@@ -17,7 +18,7 @@ type crate struct {
 
 func GetJustVersion() (string, error) {
 	data := response{}
-	client := &http.Client{}
+	client := &http.Client{Timeout: 10 * time.Second}
 	url := "https://crates.io/api/v1/crates/justjs"
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
@@ -35,6 +36,10 @@ func GetJustVersion() (string, error) {
 		defer res.Body.Close()
 	}
 
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("crates.io responded with %s", res.Status)
+	}
+
 	err = json.NewDecoder(res.Body).Decode(&data)
 	if err != nil {
 		return "", err
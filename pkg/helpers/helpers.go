@@ -1,13 +1,16 @@
 package helpers
 
 import (
-	"compress/gzip"
+	"mime"
+	"net/http"
 	"os"
    "io/fs"
+	"path"
 	"path/filepath"
 	"strings"
+	"unicode/utf8"
 
-	tarfs "github.com/nlepage/go-tarfs"
+	"registry/pkg/blob"
 )
 
 func Ternary[T any](cond bool, vtrue, vfalse T) T {
@@ -41,27 +44,157 @@ func TarPath() string {
 	}
 }
 
-func ReadFromTar(name string, source string) ([]byte, error) {
-	pkg, err := os.Open(source)
+func ReadFromTar(name string, source blob.File) ([]byte, error) {
+	index, err := OpenTarIndex(source)
 	if err != nil {
 		return nil, err
 	}
-	defer pkg.Close()
+	defer index.Close()
 
-	gz, err := gzip.NewReader(pkg)
+	return index.ReadFile(name)
+}
+
+// ReadFilesFromTar reads several files of a tarball in one pass. Names that
+// are missing are left out of the result.
+func ReadFilesFromTar(source blob.File, names []string) (map[string][]byte, error) {
+	index, err := OpenTarIndex(source)
 	if err != nil {
 		return nil, err
 	}
+	defer index.Close()
+
+	files := map[string][]byte{}
+	for _, name := range names {
+		if content, err := index.ReadFile(name); err == nil {
+			files[name] = content
+		}
+	}
+
+	return files, nil
+}
 
-	tar, err := tarfs.New(gz)
+var ReadmeNames = []string{"README.md", "README.markdown", "README.txt", "README"}
+var ChangelogNames = []string{"CHANGELOG.md", "CHANGELOG.markdown", "CHANGELOG.txt", "CHANGELOG", "CHANGES.md", "HISTORY.md"}
+
+const maxDocument = 512 * 1024
+
+// FindInTar returns the name and contents of the first top-level file that
+// matches one of the candidates, ignoring case.
+func FindInTar(source blob.File, candidates ...string) (string, []byte, error) {
+	index, err := OpenTarIndex(source)
 	if err != nil {
-		return nil, err
+		return "", nil, err
+	}
+	defer index.Close()
+
+	entries := index.Entries()
+	for _, candidate := range candidates {
+		for _, entry := range entries {
+			if strings.Contains(entry.Path, "/") || !strings.EqualFold(entry.Path, candidate) {
+				continue
+			}
+
+			content, err := index.ReadFile(entry.Path)
+			return entry.Path, content, err
+		}
+	}
+
+	return "", nil, fs.ErrNotExist
+}
+
+// Documents returns the readme and changelog of a tarball, cut to 512KB.
+// Missing files are returned as empty strings.
+func Documents(source blob.File) (string, string) {
+	documents := []string{}
+
+	for _, names := range [][]string{ReadmeNames, ChangelogNames} {
+		_, content, err := FindInTar(source, names...)
+		if err != nil {
+			documents = append(documents, "")
+			continue
+		}
+
+		documents = append(documents, Truncate(string(content), maxDocument))
+	}
+
+	return documents[0], documents[1]
+}
+
+// Truncate cuts s to at most max bytes without splitting a UTF-8 sequence.
+func Truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+
+	return s[:max]
+}
+
+func HasTypes(source blob.File) (bool, error) {
+	index, err := OpenTarIndex(source)
+	if err != nil {
+		return false, err
 	}
+	defer index.Close()
+
+	for name := range index.entries {
+		if strings.HasSuffix(name, ".d.ts") || strings.HasSuffix(name, ".d.mts") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// source files whose system mime types are missing or wrong, like .ts
+// which is usually registered as an mpeg transport stream
+var sourceTypes = map[string]string{
+	".js":   "text/javascript; charset=utf-8",
+	".mjs":  "text/javascript; charset=utf-8",
+	".cjs":  "text/javascript; charset=utf-8",
+	".jsx":  "text/javascript; charset=utf-8",
+	".ts":   "text/typescript; charset=utf-8",
+	".mts":  "text/typescript; charset=utf-8",
+	".cts":  "text/typescript; charset=utf-8",
+	".tsx":  "text/typescript; charset=utf-8",
+	".json": "application/json",
+	".map":  "application/json",
+	".md":   "text/markdown; charset=utf-8",
+}
 
-   bytes, err := fs.ReadFile(tar, name)
-   if err != nil {
-      return nil, err
-   }
+// ContentType detects the type of a file from its name, falling back to
+// sniffing the content.
+func ContentType(name string, content []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := sourceTypes[ext]; ok {
+		return contentType
+	}
+
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+
+	return http.DetectContentType(content)
+}
+
+type TarEntry struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	Mode        string `json:"mode"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"contentType"`
+}
+
+// ListTar returns every regular file of a tarball in path order.
+func ListTar(source blob.File) ([]TarEntry, error) {
+	index, err := OpenTarIndex(source)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
 
-	return bytes, nil
+	return index.Entries(), nil
 }
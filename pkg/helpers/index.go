@@ -0,0 +1,320 @@
+package helpers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"registry/pkg/blob"
+)
+
+// at most maxIndexes tarballs are kept extracted, using at most
+// maxIndexBytes of disk, and single archives larger than maxArchive are
+// not extracted at all
+const maxIndexes = 256
+const maxIndexBytes = 1 << 30
+const maxArchive = 256 << 20
+
+type indexEntry struct {
+	offset      int64
+	size        int64
+	mode        fs.FileMode
+	sha256      string
+	contentType string
+}
+
+// TarIndex holds the contents of a tarball's files in one uncompressed
+// file and the offset of every file in it, so reads don't need to unpack
+// the whole archive again. Every index returned by OpenTarIndex has to be
+// closed.
+type TarIndex struct {
+	key        string
+	file       *os.File
+	size       int64
+	entries    map[string]indexEntry
+	attributes *blob.Attributes
+
+	// refs counts the cache and every open reader, the file is closed
+	// when the last one is done
+	refs int
+}
+
+type tarIndexCache struct {
+	mu       sync.Mutex
+	dir      string
+	bytes    int64
+	order    *list.List
+	items    map[string]*list.Element
+	building map[string]*indexBuild
+}
+
+type indexBuild struct {
+	done  chan struct{}
+	index *TarIndex
+	err   error
+}
+
+var indexes = &tarIndexCache{
+	order:    list.New(),
+	items:    map[string]*list.Element{},
+	building: map[string]*indexBuild{},
+}
+
+// OpenTarIndex returns the index of a tarball, building it on first use or
+// when the tarball was rewritten since. Concurrent callers for the same
+// tarball wait for a single build.
+func OpenTarIndex(source blob.File) (*TarIndex, error) {
+	attributes, err := source.Attributes()
+	if err != nil {
+		return nil, err
+	}
+
+	c := indexes
+	c.mu.Lock()
+
+	for {
+		if element, ok := c.items[source.Key]; ok {
+			index := element.Value.(*TarIndex)
+			if sameAttributes(index.attributes, attributes) {
+				c.order.MoveToFront(element)
+				index.refs++
+				c.mu.Unlock()
+				return index, nil
+			}
+
+			c.remove(element)
+		}
+
+		build, ok := c.building[source.Key]
+		if !ok {
+			break
+		}
+
+		c.mu.Unlock()
+		<-build.done
+		if build.err != nil {
+			return nil, build.err
+		}
+		c.mu.Lock()
+	}
+
+	build := &indexBuild{done: make(chan struct{})}
+	c.building[source.Key] = build
+
+	// the directory is cleared on first use so copies left by a previous
+	// run don't pile up
+	if c.dir == "" {
+		dir := filepath.Join(os.TempDir(), "registry-tarindex")
+		if build.err = os.RemoveAll(dir); build.err == nil {
+			if build.err = os.MkdirAll(dir, 0o755); build.err == nil {
+				c.dir = dir
+			}
+		}
+	}
+	dir := c.dir
+	c.mu.Unlock()
+
+	if build.err == nil {
+		build.index, build.err = buildTarIndex(source, dir)
+	}
+
+	c.mu.Lock()
+	delete(c.building, source.Key)
+	if build.err == nil {
+		build.index.attributes = attributes
+		build.index.refs = 2
+		c.items[source.Key] = c.order.PushFront(build.index)
+		c.bytes += build.index.size
+		c.evict()
+	}
+	c.mu.Unlock()
+	close(build.done)
+
+	return build.index, build.err
+}
+
+// sameAttributes reports whether a tarball is unchanged, by its checksum
+// when the storage has one and by its size and modification time
+// otherwise.
+func sameAttributes(a *blob.Attributes, b *blob.Attributes) bool {
+	if len(a.MD5) > 0 && len(b.MD5) > 0 {
+		return bytes.Equal(a.MD5, b.MD5)
+	}
+
+	return a.Size == b.Size && a.ModTime.Equal(b.ModTime)
+}
+
+// evict drops the least recently used indexes. Their files are closed once
+// the readers that still hold them are done.
+func (c *tarIndexCache) evict() {
+	for c.order.Len() > 1 && (c.order.Len() > maxIndexes || c.bytes > maxIndexBytes) {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *tarIndexCache) remove(element *list.Element) {
+	index := element.Value.(*TarIndex)
+
+	c.order.Remove(element)
+	delete(c.items, index.key)
+	c.bytes -= index.size
+	index.release()
+}
+
+// release drops a reference to the index, it must be called with the
+// cache locked.
+func (i *TarIndex) release() {
+	i.refs--
+	if i.refs == 0 {
+		i.discard()
+	}
+}
+
+// Close releases the index. Its file stays open while the cache or other
+// readers use it.
+func (i *TarIndex) Close() error {
+	indexes.mu.Lock()
+	defer indexes.mu.Unlock()
+
+	i.release()
+	return nil
+}
+
+func buildTarIndex(source blob.File, dir string) (*TarIndex, error) {
+	pkg, err := source.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer pkg.Close()
+
+	gz, err := gzip.NewReader(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := os.CreateTemp(dir, "*.bin")
+	if err != nil {
+		return nil, err
+	}
+
+	index := &TarIndex{key: source.Key, file: out, entries: map[string]indexEntry{}}
+	archive := tar.NewReader(gz)
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			index.discard()
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		if index.size+header.Size > maxArchive {
+			index.discard()
+			return nil, fmt.Errorf("%s is larger than %d bytes once unpacked", path.Base(source.Key), maxArchive)
+		}
+
+		// the checksum and the start of the file for sniffing its type are
+		// taken while copying, so listings don't read the files again
+		hash := sha256.New()
+		head := &prefixWriter{max: 512}
+		written, err := io.Copy(io.MultiWriter(out, hash, head), archive)
+		if err != nil {
+			index.discard()
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		index.entries[name] = indexEntry{
+			offset:      index.size,
+			size:        written,
+			mode:        header.FileInfo().Mode().Perm(),
+			sha256:      fmt.Sprintf("%x", hash.Sum(nil)),
+			contentType: ContentType(name, head.buf),
+		}
+		index.size += written
+	}
+
+	return index, nil
+}
+
+// prefixWriter keeps the first max bytes written to it.
+type prefixWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if room := w.max - len(w.buf); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		w.buf = append(w.buf, p[:room]...)
+	}
+
+	return len(p), nil
+}
+
+func (i *TarIndex) discard() {
+	i.file.Close()
+	os.Remove(i.file.Name())
+}
+
+// ReadFile returns the contents of a regular file of the tarball.
+func (i *TarIndex) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, ok := i.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	content := make([]byte, entry.size)
+	if _, err := i.file.ReadAt(content, entry.offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// Entries returns every regular file of the tarball in the order a walk
+// of its directories visits them.
+func (i *TarIndex) Entries() []TarEntry {
+	entries := make([]TarEntry, 0, len(i.entries))
+	for name, entry := range i.entries {
+		entries = append(entries, TarEntry{
+			Path:        name,
+			Size:        entry.size,
+			Mode:        fmt.Sprintf("%04o", entry.mode),
+			SHA256:      entry.sha256,
+			ContentType: entry.contentType,
+		})
+	}
+
+	// the separator sorts before every other character, so the files of
+	// a directory come right after it like they do in a walk
+	sort.Slice(entries, func(a, b int) bool {
+		return strings.ReplaceAll(entries[a].Path, "/", "\x00") < strings.ReplaceAll(entries[b].Path, "/", "\x00")
+	})
+
+	return entries
+}
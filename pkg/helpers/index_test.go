@@ -0,0 +1,212 @@
+package helpers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"registry/pkg/blob"
+)
+
+func tarball(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	archive := tar.NewWriter(gz)
+
+	for name, content := range files {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.Bytes()
+}
+
+func memoryTarball(t *testing.T, key string, files map[string]string) (blob.Storage, blob.File) {
+	t.Helper()
+
+	storage := blob.NewMemory()
+	blob.Use(storage)
+	t.Cleanup(func() { blob.Use(nil) })
+
+	if err := storage.Write(key, tarball(t, files)); err != nil {
+		t.Fatal(err)
+	}
+
+	return storage, blob.File{Key: key}
+}
+
+func TestOpenTarIndex(t *testing.T) {
+	_, source := memoryTarball(t, t.Name()+"/pkg.tgz", map[string]string{
+		"index.js":     "export default 1",
+		"lib/util.js":  "export const util = true",
+		"package.json": "{}",
+	})
+
+	index, err := OpenTarIndex(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	for name, want := range map[string]string{"index.js": "export default 1", "lib/util.js": "export const util = true"} {
+		content, err := index.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile(%q): %v", name, err)
+		}
+		if string(content) != want {
+			t.Errorf("ReadFile(%q) = %q, want %q", name, content, want)
+		}
+	}
+
+	if _, err := index.ReadFile("missing.js"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile of a missing file: %v, want fs.ErrNotExist", err)
+	}
+
+	if _, err := index.ReadFile("../index.js"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("ReadFile of an invalid path: %v, want fs.ErrInvalid", err)
+	}
+
+	again, err := OpenTarIndex(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+
+	if again != index {
+		t.Error("a second open of an unchanged tarball built a new index")
+	}
+}
+
+func TestOpenTarIndexRewritten(t *testing.T) {
+	storage, source := memoryTarball(t, t.Name()+"/pkg.tgz", map[string]string{"index.js": "one"})
+
+	first, err := OpenTarIndex(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Write(source.Key, tarball(t, map[string]string{"index.js": "two"})); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ReadFromTar("index.js", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "two" {
+		t.Errorf("read %q after the tarball was rewritten, want %q", content, "two")
+	}
+
+	// the stale index stays readable until its reader is done with it
+	if content, err := first.ReadFile("index.js"); err != nil || string(content) != "one" {
+		t.Errorf("stale index read %q, %v", content, err)
+	}
+
+	name := first.file.Name()
+	first.Close()
+
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("stale index file still exists after its last reader closed it: %v", err)
+	}
+}
+
+func TestTarIndexEvicted(t *testing.T) {
+	_, source := memoryTarball(t, t.Name()+"/pkg.tgz", map[string]string{"index.js": "evicted"})
+
+	index, err := OpenTarIndex(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexes.mu.Lock()
+	indexes.remove(indexes.items[source.Key])
+	indexes.mu.Unlock()
+
+	if content, err := index.ReadFile("index.js"); err != nil || string(content) != "evicted" {
+		t.Errorf("evicted index read %q, %v", content, err)
+	}
+
+	name := index.file.Name()
+	index.Close()
+
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("evicted index file still exists after it was closed: %v", err)
+	}
+}
+
+func TestReadFilesFromTar(t *testing.T) {
+	_, source := memoryTarball(t, t.Name()+"/pkg.tgz", map[string]string{"a.js": "a", "b.js": "b"})
+
+	files, err := ReadFilesFromTar(source, []string{"a.js", "b.js", "c.js"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 || string(files["a.js"]) != "a" || string(files["b.js"]) != "b" {
+		t.Errorf("ReadFilesFromTar = %q", files)
+	}
+}
+
+func TestListTar(t *testing.T) {
+	_, source := memoryTarball(t, t.Name()+"/pkg.tgz", map[string]string{
+		"a-b.js":       "dash",
+		"a/b.js":       "nested",
+		"index.js":     "export default 1",
+		"README.md":    "# readme",
+		"types/a.d.ts": "export {}",
+	})
+
+	entries, err := ListTar(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	if got, want := strings.Join(paths, " "), "README.md a/b.js a-b.js index.js types/a.d.ts"; got != want {
+		t.Errorf("paths = %s, want %s", got, want)
+	}
+
+	index := entries[3]
+	if index.Size != 16 || index.Mode != "0644" || index.ContentType != "text/javascript; charset=utf-8" {
+		t.Errorf("index.js = %+v", index)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte("export default 1"))); index.SHA256 != want {
+		t.Errorf("index.js sha256 = %s, want %s", index.SHA256, want)
+	}
+
+	name, content, err := FindInTar(source, "readme.markdown", "readme.md")
+	if err != nil || name != "README.md" || string(content) != "# readme" {
+		t.Errorf("FindInTar = %q, %q, %v", name, content, err)
+	}
+
+	if _, _, err := FindInTar(source, "b.js"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FindInTar matched a nested file: %v", err)
+	}
+
+	if hasTypes, err := HasTypes(source); err != nil || !hasTypes {
+		t.Errorf("HasTypes = %v, %v", hasTypes, err)
+	}
+}
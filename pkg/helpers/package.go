@@ -1,6 +1,11 @@
 package helpers
 
-import "github.com/pocketbase/pocketbase/models"
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
 
 func PackagePrivacyStatus(record *models.Record) bool {
    if record.GetString("visibility") == "private" {
@@ -16,4 +21,28 @@ func PackageHasLicense(record *models.Record) string {
    }
 
    return record.GetString("license")
-}
\ No newline at end of file
+}
+
+func PackageType(record *models.Record) string {
+	if record.GetString("type") == "" {
+		return "package"
+	}
+
+	return record.GetString("type")
+}
+
+func FindUser(app core.App, identity string) (*models.Record, error) {
+	if user, err := app.Dao().FindRecordById("just_auth_system", identity); err == nil {
+		return user, nil
+	}
+
+	if user, err := app.Dao().FindAuthRecordByUsername("just_auth_system", identity); err == nil {
+		return user, nil
+	}
+
+	if user, err := app.Dao().FindAuthRecordByEmail("just_auth_system", identity); err == nil {
+		return user, nil
+	}
+
+	return nil, fmt.Errorf("user '%s' not found", identity)
+}
@@ -0,0 +1,111 @@
+package just
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
+
+type Runtime struct {
+	Minify    *bool    `json:"minify,omitempty"`
+	KeepNames *bool    `json:"keepNames,omitempty"`
+	Targets   []string `json:"targets,omitempty"`
+}
+
+func (r Runtime) ShouldMinify() bool {
+	return r.Minify == nil || *r.Minify
+}
+
+func (r Runtime) ShouldKeepNames() bool {
+	return r.KeepNames == nil || *r.KeepNames
+}
+
+func (r Runtime) AllowsTarget(target string) bool {
+	return len(r.Targets) == 0 || slices.Contains(r.Targets, target)
+}
+
+func Lookup(version string) (Runtime, bool) {
+	active.mu.RLock()
+	defer active.mu.RUnlock()
+
+	runtime, ok := active.runtimes[version]
+	if !ok && version == active.state.Version {
+		return Runtime{}, true
+	}
+
+	return runtime, ok
+}
+
+func Runtimes() []string {
+	active.mu.RLock()
+	defer active.mu.RUnlock()
+
+	return active.versions()
+}
+
+// versions lists the allowlist and the active version, which is served
+// even when it is not on the allowlist. It must be called with p.mu held.
+func (p *plugin) versions() []string {
+	versions := make([]string, 0, len(p.runtimes)+1)
+	for version := range p.runtimes {
+		versions = append(versions, version)
+	}
+	if _, ok := p.runtimes[p.state.Version]; !ok {
+		versions = append(versions, p.state.Version)
+	}
+	sort.Strings(versions)
+
+	return versions
+}
+
+func (p *plugin) loadRuntimes() {
+	runtimes := make(map[string]Runtime)
+
+	if data, err := os.ReadFile(p.runtimesPath()); err == nil {
+		if err := json.Unmarshal(data, &runtimes); err != nil {
+			log.Printf("just: unable to read %s: %v", p.runtimesPath(), err)
+		}
+	}
+
+	for _, version := range p.allowed {
+		if _, ok := runtimes[version]; !ok {
+			runtimes[version] = Runtime{}
+		}
+	}
+
+	p.mu.Lock()
+	p.runtimes = runtimes
+	p.mu.Unlock()
+}
+
+// allow must be called with p.mu held.
+func (p *plugin) allow(version string) bool {
+	if _, ok := p.runtimes[version]; ok {
+		return false
+	}
+
+	p.runtimes[version] = Runtime{}
+	return true
+}
+
+func (p *plugin) saveRuntimes() {
+	p.mu.RLock()
+	data, err := json.MarshalIndent(p.runtimes, "", "  ")
+	p.mu.RUnlock()
+
+	if err == nil {
+		err = os.WriteFile(p.runtimesPath(), data, 0644)
+	}
+
+	if err != nil {
+		log.Printf("just: unable to persist runtimes: %v", err)
+	}
+}
+
+func (p *plugin) runtimesPath() string {
+	return filepath.Join(p.app.DataDir(), "just_runtimes.json")
+}
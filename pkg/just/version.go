@@ -0,0 +1,163 @@
+package just
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"registry/pkg/helpers"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+const FallbackVersion = "latest"
+
+type State struct {
+	Version  string    `json:"version"`
+	Source   string    `json:"source"`
+	Updated  time.Time `json:"updated"`
+	Refresh  string    `json:"refresh"`
+	Runtimes []string  `json:"runtimes"`
+}
+
+type plugin struct {
+	app     core.App
+	version string
+	refresh time.Duration
+	allowed []string
+
+	mu       sync.RWMutex
+	state    State
+	runtimes map[string]Runtime
+}
+
+var active = &plugin{
+	state:    State{Version: FallbackVersion, Source: "fallback"},
+	runtimes: map[string]Runtime{},
+}
+
+func Register(app core.App, rootCmd *cobra.Command) {
+	active.app = app
+
+	rootCmd.PersistentFlags().StringVar(
+		&active.version,
+		"justVersion",
+		os.Getenv("JUST_VERSION"),
+		"the Just runtime version used in module urls (default $JUST_VERSION or the last known value)",
+	)
+
+	rootCmd.PersistentFlags().DurationVar(
+		&active.refresh,
+		"justRefresh",
+		0,
+		"interval for refreshing the Just runtime version from crates.io (0 disables, ignored with --justVersion)",
+	)
+
+	rootCmd.PersistentFlags().StringSliceVar(
+		&active.allowed,
+		"justRuntimes",
+		nil,
+		"additional Just runtime versions served next to the active one (see just_runtimes.json for build options)",
+	)
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		active.loadRuntimes()
+		active.resolve()
+
+		if active.refresh > 0 && active.version == "" {
+			go active.refresher()
+		}
+
+		return nil
+	})
+}
+
+func Version() string {
+	active.mu.RLock()
+	defer active.mu.RUnlock()
+
+	return active.state.Version
+}
+
+func Current() State {
+	active.mu.RLock()
+	defer active.mu.RUnlock()
+
+	state := active.state
+	state.Refresh = active.refresh.String()
+	state.Runtimes = active.versions()
+
+	return state
+}
+
+// resolve picks the version to serve on startup from the config, the last
+// persisted version or the built-in fallback, without touching the
+// network. Newer versions only come from the background refresher.
+func (p *plugin) resolve() {
+	if p.version != "" {
+		p.set(p.version, "config", true)
+		return
+	}
+
+	if persisted := p.load(); persisted != "" {
+		p.set(persisted, "persisted", false)
+		return
+	}
+
+	p.set(FallbackVersion, "fallback", false)
+}
+
+func (p *plugin) refresher() {
+	for {
+		if err := p.fetch(); err != nil {
+			log.Printf("just: unable to refresh runtime version: %v", err)
+		}
+		time.Sleep(p.refresh)
+	}
+}
+
+func (p *plugin) fetch() error {
+	version, err := helpers.GetJustVersion()
+	if err != nil {
+		return err
+	}
+
+	p.set(version, "crates.io", true)
+	return nil
+}
+
+// set makes version the active runtime and adds it to the allowlist, so
+// module urls pinned to it keep working after a newer version replaces it.
+func (p *plugin) set(version string, source string, persist bool) {
+	p.mu.Lock()
+	p.state = State{Version: version, Source: source, Updated: time.Now().UTC()}
+	added := p.allow(version)
+	p.mu.Unlock()
+
+	if added {
+		p.saveRuntimes()
+	}
+
+	if persist {
+		if err := os.WriteFile(p.path(), []byte(version), 0644); err != nil {
+			log.Printf("just: unable to persist runtime version: %v", err)
+		}
+	}
+}
+
+func (p *plugin) load() string {
+	data, err := os.ReadFile(p.path())
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func (p *plugin) path() string {
+	return filepath.Join(p.app.DataDir(), "just_version")
+}
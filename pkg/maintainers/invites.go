@@ -0,0 +1,253 @@
+package maintainers
+
+import (
+	"errors"
+	"fmt"
+
+	"registry/pkg/audit"
+	"registry/pkg/auth"
+	"registry/pkg/helpers"
+	"registry/pkg/orgs"
+	"registry/pkg/store"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"golang.org/x/exp/slices"
+)
+
+const invitesCollection = "just_invites"
+
+var ErrNoInvite = errors.New("you have not been invited to maintain this package")
+
+// Invite is a pending request for a user to become a maintainer. The user
+// only gets access once they accept it.
+type Invite struct {
+	Id        string         `json:"id"`
+	Package   string         `json:"package"`
+	User      string         `json:"user"`
+	InvitedBy string         `json:"invitedBy"`
+	Created   types.DateTime `json:"created"`
+}
+
+func inviteCollection(app core.App) (*models.Collection, error) {
+	if exists, _ := app.Dao().FindCollectionByNameOrId(invitesCollection); exists != nil {
+		return exists, nil
+	}
+
+	collection := &models.Collection{}
+	form := forms.NewCollectionUpsert(app, collection)
+	form.Name = invitesCollection
+	form.Type = models.CollectionTypeBase
+	form.ListRule = nil
+	form.ViewRule = nil
+	form.CreateRule = nil
+	form.UpdateRule = nil
+	form.DeleteRule = nil
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "package",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "user",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   false,
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "invitedBy",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   false,
+	})
+
+	if err := form.Submit(); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func inviteInfo(record *models.Record) *Invite {
+	return &Invite{
+		Id:        record.Id,
+		Package:   record.GetString("package"),
+		User:      record.GetString("user"),
+		InvitedBy: record.GetString("invitedBy"),
+		Created:   record.Created,
+	}
+}
+
+func findInvite(dao *daos.Dao, name string, user string) (*models.Record, error) {
+	records, err := dao.FindRecordsByExpr(invitesCollection, dbx.HashExp{"package": name, "user": user})
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+
+	return records[0], nil
+}
+
+// Add invites a user to maintain a package.
+func Add(app core.App, c echo.Context, name string, identity string) (*Invite, error) {
+	target, err := helpers.FindUser(app, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := List(app, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !auth.HasRole(app, c, name, orgs.RoleMaintainer) {
+		return nil, ErrForbidden
+	}
+
+	if slices.Contains(access, target.Id) {
+		return nil, fmt.Errorf("'%s' is already a maintainer", target.Username())
+	}
+
+	collection, err := inviteCollection(app)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, err := findInvite(app.Dao(), name, target.Id); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, fmt.Errorf("'%s' has already been invited", target.Username())
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("package", name)
+	record.Set("user", target.Id)
+	record.Set("invitedBy", audit.Actor(c))
+
+	if err := app.Dao().SaveRecord(record); err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, name, "maintainer.invite", target.Id, nil)
+	return inviteInfo(record), nil
+}
+
+// Invites lists the pending invites of a package to its maintainers.
+func Invites(app core.App, c echo.Context, name string) ([]*Invite, error) {
+	if _, err := List(app, name); err != nil {
+		return nil, err
+	}
+
+	if !auth.HasRole(app, c, name, orgs.RoleMaintainer) {
+		return nil, ErrForbidden
+	}
+
+	invites := []*Invite{}
+	if exists, _ := app.Dao().FindCollectionByNameOrId(invitesCollection); exists == nil {
+		return invites, nil
+	}
+
+	records, err := app.Dao().FindRecordsByExpr(invitesCollection, dbx.HashExp{"package": name})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		invites = append(invites, inviteInfo(record))
+	}
+
+	return invites, nil
+}
+
+// Accept makes the caller a maintainer of a package they were invited to.
+func Accept(app core.App, c echo.Context, name string) ([]string, error) {
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if user == nil {
+		return nil, ErrNoInvite
+	}
+
+	if _, err := inviteCollection(app); err != nil {
+		return nil, err
+	}
+
+	var result []string
+	err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		invite, err := findInvite(txDao, name, user.Id)
+		if err != nil {
+			return err
+		}
+		if invite == nil {
+			return ErrNoInvite
+		}
+
+		pkg, err := txDao.FindFirstRecordByData(store.PackagesCollection, "name", name)
+		if err != nil {
+			return ErrNotFound
+		}
+
+		result = pkg.GetStringSlice("access")
+		if !slices.Contains(result, user.Id) {
+			result = append(result, user.Id)
+			pkg.Set("access", result)
+			if err := txDao.SaveRecord(pkg); err != nil {
+				return err
+			}
+		}
+
+		return txDao.DeleteRecord(invite)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, name, "maintainer.add", user.Id, nil)
+	return result, nil
+}
+
+// Decline drops the invite of a user. Invited users decline their own
+// invites, maintainers can withdraw the invite of anyone.
+func Decline(app core.App, c echo.Context, name string, identity string) error {
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if identity == "" && user != nil {
+		identity = user.Id
+	}
+
+	target, err := helpers.FindUser(app, identity)
+	if err != nil {
+		return err
+	}
+
+	isSelf := user != nil && user.Id == target.Id
+	if !isSelf && !auth.HasRole(app, c, name, orgs.RoleMaintainer) {
+		return ErrForbidden
+	}
+
+	if exists, _ := app.Dao().FindCollectionByNameOrId(invitesCollection); exists == nil {
+		return ErrNoInvite
+	}
+
+	invite, err := findInvite(app.Dao(), name, target.Id)
+	if err != nil {
+		return err
+	}
+	if invite == nil {
+		return ErrNoInvite
+	}
+
+	if err := app.Dao().DeleteRecord(invite); err != nil {
+		return err
+	}
+
+	audit.Log(app, c, name, "maintainer.decline", target.Id, nil)
+	return nil
+}
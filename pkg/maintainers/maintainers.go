@@ -0,0 +1,128 @@
+package maintainers
+
+import (
+	"errors"
+	"fmt"
+
+	"registry/pkg/audit"
+	"registry/pkg/auth"
+	"registry/pkg/helpers"
+	"registry/pkg/orgs"
+	"registry/pkg/store"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"golang.org/x/exp/slices"
+)
+
+var ErrForbidden = errors.New("you are not allowed to manage the maintainers of this package")
+var ErrOwnerOnly = errors.New("only the owner can transfer ownership")
+var ErrNotFound = errors.New("package not found")
+
+func List(app core.App, name string) ([]string, error) {
+	pkg, err := store.FindPackage(app, name)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pkg.GetStringSlice("access"), nil
+}
+
+func update(app core.App, name string, change func(access []string) ([]string, error)) ([]string, error) {
+	var result []string
+	err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		pkg, err := txDao.FindFirstRecordByData(store.PackagesCollection, "name", name)
+		if err != nil {
+			return ErrNotFound
+		}
+
+		result, err = change(pkg.GetStringSlice("access"))
+		if err != nil {
+			return err
+		}
+
+		if len(result) == 0 {
+			return errors.New("a package must keep at least one maintainer")
+		}
+
+		pkg.Set("access", result)
+		return txDao.SaveRecord(pkg)
+	})
+
+	return result, err
+}
+
+func Remove(app core.App, c echo.Context, name string, identity string) ([]string, error) {
+	target, err := helpers.FindUser(app, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	isOwner := auth.HasRole(app, c, name, orgs.RoleOwner)
+	isSelf := user != nil && user.Id == target.Id
+
+	access, err := update(app, name, func(access []string) ([]string, error) {
+		if !isOwner && !isSelf {
+			return nil, ErrForbidden
+		}
+
+		index := slices.Index(access, target.Id)
+		if index == -1 {
+			return nil, fmt.Errorf("'%s' is not a maintainer", target.Username())
+		}
+
+		if index == 0 {
+			return nil, errors.New("the owner cannot be removed, transfer ownership first")
+		}
+
+		return slices.Delete(access, index, index+1), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, name, "maintainer.remove", target.Id, nil)
+	return access, nil
+}
+
+func Transfer(app core.App, c echo.Context, name string, identity string) ([]string, error) {
+	target, err := helpers.FindUser(app, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous string
+	isOwner := auth.HasRole(app, c, name, orgs.RoleOwner)
+	access, err := update(app, name, func(access []string) ([]string, error) {
+		if len(access) > 0 {
+			previous = access[0]
+		}
+
+		if !isOwner {
+			return nil, ErrOwnerOnly
+		}
+
+		if previous == target.Id {
+			return nil, fmt.Errorf("'%s' already owns this package", target.Username())
+		}
+
+		if index := slices.Index(access, target.Id); index != -1 {
+			access = slices.Delete(access, index, index+1)
+		}
+
+		return append([]string{target.Id}, access...), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, name, "owner.transfer", target.Id, map[string]any{"previous": previous})
+	return access, nil
+}
@@ -0,0 +1,63 @@
+package mirror
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+func NewExportCommand(app core.App) *cobra.Command {
+	var output string
+
+	command := &cobra.Command{
+		Use:   "export [package[@version]...]",
+		Short: "Exports packages and their tarballs into a portable mirror archive",
+		Long: `
+Exports the selected packages (or every package when none are given) into a
+single gzipped archive containing a manifest with checksums and all tarballs.
+Use "name@version" to export only specific versions of a package.
+`,
+		RunE: func(command *cobra.Command, args []string) error {
+			manifest, err := Export(app, args, output)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("exported %d package(s) to %s\n", len(manifest.Packages), output)
+			return nil
+		},
+	}
+
+	command.Flags().StringVarP(&output, "output", "o", "registry-mirror.tgz", "the archive to write")
+
+	return command
+}
+
+func NewImportCommand(app core.App) *cobra.Command {
+	var owner string
+
+	command := &cobra.Command{
+		Use:   "import [archive]",
+		Short: "Imports a mirror archive created with the export command",
+		Long: `
+Imports every package in the archive, recreating missing package collections.
+Versions that already exist are skipped. Maintainers that do not exist on this
+instance are dropped, use --owner to assign versions left without maintainers.
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(command *cobra.Command, args []string) error {
+			manifest, err := Import(app, args[0], owner)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("imported %d package(s) from %s\n", len(manifest.Packages), args[0])
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&owner, "owner", "", "the just_auth_system user id assigned when no maintainer exists")
+
+	return command
+}
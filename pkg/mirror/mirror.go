@@ -0,0 +1,483 @@
+package mirror
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"registry/pkg/blob"
+	"registry/pkg/helpers"
+	"registry/pkg/parse"
+	"registry/pkg/search"
+	"registry/pkg/store"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+const manifestName = "manifest.json"
+const manifestFormat = 1
+
+type Manifest struct {
+	Format   int               `json:"format"`
+	Created  time.Time         `json:"created"`
+	Packages []ManifestPackage `json:"packages"`
+}
+
+type ManifestPackage struct {
+	Name       string            `json:"name"`
+	Collection string            `json:"collection"`
+	Versions   []ManifestVersion `json:"versions"`
+}
+
+type ManifestVersion struct {
+	Version      string          `json:"version"`
+	Type         string          `json:"type"`
+	Visibility   string          `json:"visibility"`
+	Group        string          `json:"group"`
+	Description  string          `json:"description"`
+	Keywords     []string        `json:"keywords,omitempty"`
+	Index        string          `json:"index"`
+	Author       string          `json:"author"`
+	Url          string          `json:"url"`
+	Repository   string          `json:"repository"`
+	License      string          `json:"license"`
+	Access       []string        `json:"access"`
+	Dependencies json.RawMessage `json:"dependencies"`
+	Published    string          `json:"published"`
+	Tarball      string          `json:"tarball"`
+	Size         int64           `json:"size"`
+	Sha256       string          `json:"sha256"`
+}
+
+func selection(specs []string) (map[string][]string, error) {
+	selected := make(map[string][]string)
+
+	for _, spec := range specs {
+		name, version := spec, ""
+		if parse.HasSemVersion(spec) {
+			version = parse.GetSemVer(spec)
+			name = strings.TrimSuffix(spec, fmt.Sprintf("@%s", version))
+		}
+
+		if _, err := parse.EncodeName(name); err != nil {
+			return nil, fmt.Errorf("%s: %w", spec, err)
+		}
+
+		if version == "" {
+			selected[name] = []string{}
+		} else if versions, ok := selected[name]; !ok || len(versions) > 0 {
+			selected[name] = append(versions, version)
+		}
+	}
+
+	return selected, nil
+}
+
+func Export(app core.App, specs []string, destination string) (*Manifest, error) {
+	selected, err := selection(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	packages := []*models.Record{}
+	if exists, _ := app.Dao().FindCollectionByNameOrId(store.PackagesCollection); exists != nil {
+		if packages, err = app.Dao().FindRecordsByExpr(store.PackagesCollection); err != nil {
+			return nil, err
+		}
+	}
+
+	// the archive is written next to its destination and only renamed
+	// into place once it is complete
+	out, err := os.CreateTemp(filepath.Dir(destination), "."+filepath.Base(destination)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	archive := tar.NewWriter(gz)
+	manifest := &Manifest{Format: manifestFormat, Created: time.Now().UTC()}
+
+	filtered := len(selected) > 0
+	for _, metadata := range packages {
+		name := metadata.GetString("name")
+		versions, ok := selected[name]
+		if filtered && !ok {
+			continue
+		}
+		delete(selected, name)
+
+		exprs := []dbx.Expression{}
+		if len(versions) > 0 {
+			exprs = append(exprs, dbx.In("version", toAny(versions)...))
+		}
+
+		records, err := store.Versions(app, metadata, exprs...)
+		if err != nil {
+			return nil, err
+		}
+
+		encodedName, err := parse.EncodeName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		pkg := ManifestPackage{Name: name, Collection: encodedName}
+		for _, record := range records {
+			entry, err := exportVersion(app, archive, encodedName, metadata, record)
+			if err != nil {
+				return nil, err
+			}
+			pkg.Versions = append(pkg.Versions, *entry)
+		}
+
+		manifest.Packages = append(manifest.Packages, pkg)
+	}
+
+	for missing := range selected {
+		return nil, fmt.Errorf("package '%s' not found", missing)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeEntry(archive, manifestName, int64(len(data)), strings.NewReader(string(data))); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := out.Chmod(0644); err != nil {
+		return nil, err
+	}
+
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(out.Name(), destination); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func exportVersion(app core.App, archive *tar.Writer, encodedName string, pkg *models.Record, record *models.Record) (*ManifestVersion, error) {
+	file, err := blob.Tarball(app, record).Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	entryName := fmt.Sprintf("tarballs/%s/%s.tgz", encodedName, record.GetString("version"))
+
+	if err := writeEntry(archive, entryName, file.Size(), io.TeeReader(file, hash)); err != nil {
+		return nil, err
+	}
+
+	dependencies := json.RawMessage(record.GetString("dependencies"))
+	if len(dependencies) == 0 {
+		dependencies = json.RawMessage("{}")
+	}
+
+	return &ManifestVersion{
+		Version:      record.GetString("version"),
+		Type:         helpers.PackageType(pkg),
+		Visibility:   pkg.GetString("visibility"),
+		Group:        pkg.GetString("group"),
+		Description:  pkg.GetString("description"),
+		Keywords:     pkg.GetStringSlice("keywords"),
+		Index:        record.GetString("index"),
+		Author:       record.GetString("author"),
+		Url:          pkg.GetString("url"),
+		Repository:   pkg.GetString("repository"),
+		License:      pkg.GetString("license"),
+		Access:       pkg.GetStringSlice("access"),
+		Dependencies: dependencies,
+		Published:    record.Created.String(),
+		Tarball:      entryName,
+		Size:         file.Size(),
+		Sha256:       fmt.Sprintf("%x", hash.Sum(nil)),
+	}, nil
+}
+
+func writeEntry(archive *tar.Writer, name string, size int64, content io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(archive, content)
+	return err
+}
+
+func Import(app core.App, source string, owner string) (*Manifest, error) {
+	workDir, err := os.MkdirTemp("", "registry-mirror-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := extract(source, workDir); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(workDir, manifestName))
+	if err != nil {
+		return nil, errors.New("archive does not contain a manifest")
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+
+	if manifest.Format != manifestFormat {
+		return nil, fmt.Errorf("unsupported manifest format %d", manifest.Format)
+	}
+
+	for _, pkg := range manifest.Packages {
+		for _, version := range pkg.Versions {
+			path, err := bundlePath(workDir, version.Tarball)
+			if err == nil {
+				err = verify(path, version.Sha256)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s@%s: %w", pkg.Name, version.Version, err)
+			}
+		}
+	}
+
+	for _, pkg := range manifest.Packages {
+		encodedName, err := parse.EncodeName(pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if encodedName != pkg.Collection {
+			return nil, fmt.Errorf("%s: collection name does not match package name", pkg.Name)
+		}
+
+		if _, err := store.EnsureVersions(app.Dao()); err != nil {
+			return nil, err
+		}
+
+		metadata, err := importPackage(app, pkg, owner)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+
+		for _, version := range pkg.Versions {
+			if err := importVersion(app, metadata, workDir, version); err != nil {
+				return nil, fmt.Errorf("%s@%s: %w", pkg.Name, version.Version, err)
+			}
+		}
+
+		if err := search.Index(app, metadata); err != nil {
+			return nil, fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+	}
+
+	return manifest, nil
+}
+
+func importPackage(app core.App, pkg ManifestPackage, owner string) (*models.Record, error) {
+	if existing, err := store.FindPackage(app, pkg.Name); err == nil {
+		return existing, nil
+	}
+
+	if len(pkg.Versions) == 0 {
+		return nil, errors.New("package has no versions")
+	}
+	latest := pkg.Versions[len(pkg.Versions)-1]
+
+	access := []string{}
+	for _, id := range latest.Access {
+		if user, _ := app.Dao().FindRecordById("just_auth_system", id); user != nil {
+			access = append(access, id)
+		}
+	}
+
+	if len(access) == 0 {
+		if owner == "" {
+			return nil, errors.New("none of the maintainers exist on this instance, use --owner to assign one")
+		}
+		access = append(access, owner)
+	}
+
+	return store.SavePackage(app, pkg.Name, map[string]any{
+		"access":      access,
+		"type":        latest.Type,
+		"visibility":  latest.Visibility,
+		"group":       latest.Group,
+		"description": latest.Description,
+		"keywords":    latest.Keywords,
+		"url":         latest.Url,
+		"repository":  latest.Repository,
+		"license":     latest.License,
+	})
+}
+
+func importVersion(app core.App, pkg *models.Record, workDir string, version ManifestVersion) error {
+	if _, err := store.FindVersion(app, pkg, version.Version); err == nil {
+		return nil
+	}
+
+	collection, err := app.Dao().FindCollectionByNameOrId(store.VersionsCollection)
+	if err != nil {
+		return err
+	}
+
+	path, err := bundlePath(workDir, version.Tarball)
+	if err != nil {
+		return err
+	}
+
+	tarball, err := filesystem.NewFileFromPath(path)
+	if err != nil {
+		return err
+	}
+
+	dependencies := make(map[string]string)
+	if err := json.Unmarshal(version.Dependencies, &dependencies); err != nil {
+		return err
+	}
+
+	record := models.NewRecord(collection)
+	form := forms.NewRecordUpsert(app, record)
+
+	if err := form.LoadData(map[string]any{
+		"package":      pkg.Id,
+		"index":        version.Index,
+		"author":       version.Author,
+		"dependencies": dependencies,
+		"version":      version.Version,
+	}); err != nil {
+		return err
+	}
+
+	if err := form.AddFiles("tarball", tarball); err != nil {
+		return err
+	}
+
+	if err := form.Submit(); err != nil {
+		return err
+	}
+
+	readme, changelog := helpers.Documents(blob.Tarball(app, record))
+	return store.SaveDocuments(app.Dao(), record, readme, changelog)
+}
+
+func extract(source string, destination string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target := filepath.Join(destination, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(destination)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path '%s' in archive", header.Name)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(out, archive)
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// bundlePath returns the path of a file of an extracted bundle, refusing
+// names from the manifest that are absolute or point outside of it.
+func bundlePath(workDir string, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || clean == ".." || strings.HasPrefix(clean, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid tarball path '%s' in manifest", name)
+	}
+
+	return filepath.Join(workDir, clean), nil
+}
+
+func verify(path string, checksum string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	if fmt.Sprintf("%x", hash.Sum(nil)) != checksum {
+		return errors.New("checksum mismatch")
+	}
+
+	return nil
+}
+
+func toAny(values []string) []any {
+	result := make([]any, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
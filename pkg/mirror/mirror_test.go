@@ -0,0 +1,34 @@
+package mirror
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBundlePath(t *testing.T) {
+	workDir := t.TempDir()
+
+	for name, valid := range map[string]bool{
+		"tarballs/pkg/1.0.0.tgz":       true,
+		"./tarballs/pkg.tgz":           true,
+		"tarballs/../pkg.tgz":          true,
+		"":                             false,
+		"..":                           false,
+		"../outside.tgz":               false,
+		"tarballs/../../outside.tgz":   false,
+		"/etc/passwd":                  false,
+		"/tmp/registry-mirror/pkg.tgz": false,
+	} {
+		path, err := bundlePath(workDir, name)
+		if valid != (err == nil) {
+			t.Errorf("bundlePath(%q) = %q, %v", name, path, err)
+			continue
+		}
+
+		if valid {
+			if rel, err := filepath.Rel(workDir, path); err != nil || rel == ".." || filepath.IsAbs(rel) {
+				t.Errorf("bundlePath(%q) = %q is outside the bundle", name, path)
+			}
+		}
+	}
+}
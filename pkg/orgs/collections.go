@@ -0,0 +1,140 @@
+package orgs
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const orgsCollection = "just_orgs"
+const membersCollection = "just_org_members"
+const teamsCollection = "just_teams"
+
+func ensure(app core.App, name string, build func(form *forms.CollectionUpsert, users *models.Collection, orgs *models.Collection)) (*models.Collection, error) {
+	if exists, _ := app.Dao().FindCollectionByNameOrId(name); exists != nil {
+		return exists, nil
+	}
+
+	users, err := app.Dao().FindCollectionByNameOrId("just_auth_system")
+	if err != nil {
+		return nil, err
+	}
+
+	var orgs *models.Collection
+	if name != orgsCollection {
+		if orgs, err = ensure(app, orgsCollection, orgFields); err != nil {
+			return nil, err
+		}
+	}
+
+	collection := &models.Collection{}
+	form := forms.NewCollectionUpsert(app, collection)
+	form.Name = name
+	form.Type = models.CollectionTypeBase
+	form.ListRule = nil
+	form.ViewRule = nil
+	form.CreateRule = nil
+	form.UpdateRule = nil
+	form.DeleteRule = nil
+
+	build(form, users, orgs)
+
+	if err := form.Submit(); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func roleField(values ...string) *schema.SchemaField {
+	return &schema.SchemaField{
+		Name:     "role",
+		Type:     schema.FieldTypeSelect,
+		Required: true,
+		Unique:   false,
+		Options: &schema.SelectOptions{
+			MaxSelect: 1,
+			Values:    values,
+		},
+	}
+}
+
+func orgRelation(orgs *models.Collection) *schema.SchemaField {
+	return &schema.SchemaField{
+		Name:     "org",
+		Type:     schema.FieldTypeRelation,
+		Required: true,
+		Unique:   false,
+		Options: &schema.RelationOptions{
+			MaxSelect:     types.Pointer(1),
+			CollectionId:  orgs.Id,
+			CascadeDelete: true,
+		},
+	}
+}
+
+func orgFields(form *forms.CollectionUpsert, users *models.Collection, orgs *models.Collection) {
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "name",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   true,
+		Options: &schema.TextOptions{
+			Pattern: `^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`,
+		},
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "description",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Unique:   false,
+	})
+}
+
+func memberFields(form *forms.CollectionUpsert, users *models.Collection, orgs *models.Collection) {
+	form.Schema.AddField(orgRelation(orgs))
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "user",
+		Type:     schema.FieldTypeRelation,
+		Required: true,
+		Unique:   false,
+		Options: &schema.RelationOptions{
+			MaxSelect:     types.Pointer(1),
+			CollectionId:  users.Id,
+			CascadeDelete: true,
+		},
+	})
+
+	form.Schema.AddField(roleField(RoleOwner, RoleMaintainer, RoleRead))
+}
+
+func teamFields(form *forms.CollectionUpsert, users *models.Collection, orgs *models.Collection) {
+	form.Schema.AddField(orgRelation(orgs))
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "name",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Unique:   false,
+		Options: &schema.TextOptions{
+			Pattern: `^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`,
+		},
+	})
+
+	form.Schema.AddField(&schema.SchemaField{
+		Name:     "members",
+		Type:     schema.FieldTypeRelation,
+		Required: false,
+		Unique:   false,
+		Options: &schema.RelationOptions{
+			CollectionId:  users.Id,
+			CascadeDelete: false,
+		},
+	})
+
+	form.Schema.AddField(roleField(RoleMaintainer, RoleRead))
+}
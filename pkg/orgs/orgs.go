@@ -0,0 +1,403 @@
+package orgs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"registry/pkg/audit"
+	"registry/pkg/helpers"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	RoleOwner      = "owner"
+	RoleMaintainer = "maintainer"
+	RoleRead       = "read"
+)
+
+var ErrNotFound = errors.New("organization not found")
+var ErrForbidden = errors.New("only organization owners can manage this organization")
+
+type Member struct {
+	User     string `json:"user"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+type Team struct {
+	Name    string   `json:"name"`
+	Role    string   `json:"role"`
+	Members []string `json:"members"`
+}
+
+type Org struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Members     []Member `json:"members"`
+	Teams       []Team   `json:"teams"`
+}
+
+func Rank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 3
+	case RoleMaintainer:
+		return 2
+	case RoleRead:
+		return 1
+	}
+	return 0
+}
+
+func Highest(roles ...string) string {
+	highest := ""
+	for _, role := range roles {
+		if Rank(role) > Rank(highest) {
+			highest = role
+		}
+	}
+	return highest
+}
+
+func findFirst(app core.App, collection string, exprs dbx.HashExp) (*models.Record, error) {
+	records, err := app.Dao().FindRecordsByExpr(collection, exprs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("record not found")
+	}
+
+	return records[0], nil
+}
+
+func Find(app core.App, name string) (*models.Record, error) {
+	if exists, _ := app.Dao().FindCollectionByNameOrId(orgsCollection); exists == nil {
+		return nil, ErrNotFound
+	}
+
+	record, err := app.Dao().FindFirstRecordByData(orgsCollection, "name", name)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	return record, nil
+}
+
+func Role(app core.App, userId string, name string) string {
+	org, err := Find(app, name)
+	if err != nil || userId == "" {
+		return ""
+	}
+
+	roles := []string{}
+	if members, err := app.Dao().FindRecordsByExpr(membersCollection, dbx.HashExp{"org": org.Id, "user": userId}); err == nil {
+		for _, member := range members {
+			roles = append(roles, member.GetString("role"))
+		}
+	}
+
+	if teams, err := app.Dao().FindRecordsByExpr(teamsCollection, dbx.HashExp{"org": org.Id}); err == nil {
+		for _, team := range teams {
+			if slices.Contains(team.GetStringSlice("members"), userId) {
+				roles = append(roles, team.GetString("role"))
+			}
+		}
+	}
+
+	return Highest(roles...)
+}
+
+func requireOwner(app core.App, c echo.Context, name string) (*models.Record, error) {
+	org, err := Find(app, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		return org, nil
+	}
+
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if user == nil || Role(app, user.Id, name) != RoleOwner {
+		return nil, ErrForbidden
+	}
+
+	return org, nil
+}
+
+func Create(app core.App, c echo.Context, name string, description string) (*Org, error) {
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if user == nil {
+		return nil, errors.New("organizations must be created by a user")
+	}
+
+	if !validName(name) {
+		return nil, fmt.Errorf("invalid organization name '%s', use letters, digits, '.', '_' and '-'", name)
+	}
+
+	if _, err := Find(app, name); err == nil {
+		return nil, fmt.Errorf("organization '%s' already exists", name)
+	}
+
+	collection, err := ensure(app, orgsCollection, orgFields)
+	if err != nil {
+		return nil, err
+	}
+
+	org := models.NewRecord(collection)
+	org.Set("name", name)
+	org.Set("description", description)
+	if err := app.Dao().SaveRecord(org); err != nil {
+		return nil, err
+	}
+
+	if err := saveMember(app, org, user.Id, RoleOwner); err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, "@"+name, "org.create", user.Id, nil)
+	return Get(app, name)
+}
+
+// names become the scope of packages like @name:package, so they can't
+// contain the characters that separate the two
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+func validName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// View returns an organization as the caller may see it: members and admins
+// get its members and teams, everyone else only its name and description.
+func View(app core.App, c echo.Context, name string) (*Org, error) {
+	org, err := Get(app, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		return org, nil
+	}
+
+	if user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record); user != nil && Role(app, user.Id, name) != "" {
+		return org, nil
+	}
+
+	org.Members = []Member{}
+	org.Teams = []Team{}
+	return org, nil
+}
+
+func Get(app core.App, name string) (*Org, error) {
+	org, err := Find(app, name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Org{Name: name, Description: org.GetString("description"), Members: []Member{}, Teams: []Team{}}
+
+	if members, err := app.Dao().FindRecordsByExpr(membersCollection, dbx.HashExp{"org": org.Id}); err == nil {
+		for _, member := range members {
+			username := ""
+			if user, err := app.Dao().FindRecordById("just_auth_system", member.GetString("user")); err == nil {
+				username = user.Username()
+			}
+			result.Members = append(result.Members, Member{User: member.GetString("user"), Username: username, Role: member.GetString("role")})
+		}
+	}
+
+	if teams, err := app.Dao().FindRecordsByExpr(teamsCollection, dbx.HashExp{"org": org.Id}); err == nil {
+		for _, team := range teams {
+			result.Teams = append(result.Teams, Team{Name: team.GetString("name"), Role: team.GetString("role"), Members: team.GetStringSlice("members")})
+		}
+	}
+
+	return result, nil
+}
+
+func saveMember(app core.App, org *models.Record, userId string, role string) error {
+	collection, err := ensure(app, membersCollection, memberFields)
+	if err != nil {
+		return err
+	}
+
+	member, _ := findFirst(app, membersCollection, dbx.HashExp{"org": org.Id, "user": userId})
+	if member == nil {
+		member = models.NewRecord(collection)
+		member.Set("org", org.Id)
+		member.Set("user", userId)
+	}
+
+	member.Set("role", role)
+	return app.Dao().SaveRecord(member)
+}
+
+func owners(app core.App, org *models.Record) int {
+	owners, err := app.Dao().FindRecordsByExpr(membersCollection, dbx.HashExp{"org": org.Id, "role": RoleOwner})
+	if err != nil {
+		return 0
+	}
+	return len(owners)
+}
+
+func SetMember(app core.App, c echo.Context, name string, identity string, role string) (*Org, error) {
+	org, err := requireOwner(app, c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if Rank(role) == 0 {
+		return nil, fmt.Errorf("invalid role '%s'", role)
+	}
+
+	user, err := helpers.FindUser(app, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if role != RoleOwner && Role(app, user.Id, name) == RoleOwner && owners(app, org) <= 1 {
+		return nil, errors.New("an organization must keep at least one owner")
+	}
+
+	if err := saveMember(app, org, user.Id, role); err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, "@"+name, "org.member.set", user.Id, map[string]any{"role": role})
+	return Get(app, name)
+}
+
+func RemoveMember(app core.App, c echo.Context, name string, identity string) (*Org, error) {
+	org, err := requireOwner(app, c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := helpers.FindUser(app, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := findFirst(app, membersCollection, dbx.HashExp{"org": org.Id, "user": user.Id})
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a member of @%s", user.Username(), name)
+	}
+
+	if member.GetString("role") == RoleOwner && owners(app, org) <= 1 {
+		return nil, errors.New("an organization must keep at least one owner")
+	}
+
+	if err := app.Dao().DeleteRecord(member); err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, "@"+name, "org.member.remove", user.Id, nil)
+	return Get(app, name)
+}
+
+func findTeam(app core.App, org *models.Record, team string) (*models.Record, error) {
+	record, err := findFirst(app, teamsCollection, dbx.HashExp{"org": org.Id, "name": team})
+	if err != nil {
+		return nil, fmt.Errorf("team '%s' not found", team)
+	}
+	return record, nil
+}
+
+func SetTeam(app core.App, c echo.Context, name string, team string, role string) (*Org, error) {
+	org, err := requireOwner(app, c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if role != RoleMaintainer && role != RoleRead {
+		return nil, fmt.Errorf("invalid team role '%s'", role)
+	}
+
+	collection, err := ensure(app, teamsCollection, teamFields)
+	if err != nil {
+		return nil, err
+	}
+
+	record, _ := findTeam(app, org, team)
+	if record == nil {
+		record = models.NewRecord(collection)
+		record.Set("org", org.Id)
+		record.Set("name", team)
+		record.Set("members", []string{})
+	}
+
+	record.Set("role", role)
+	if err := app.Dao().SaveRecord(record); err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, "@"+name, "org.team.set", team, map[string]any{"role": role})
+	return Get(app, name)
+}
+
+func RemoveTeam(app core.App, c echo.Context, name string, team string) (*Org, error) {
+	org, err := requireOwner(app, c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := findTeam(app, org, team)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.Dao().DeleteRecord(record); err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, "@"+name, "org.team.remove", team, nil)
+	return Get(app, name)
+}
+
+func SetTeamMember(app core.App, c echo.Context, name string, team string, identity string, present bool) (*Org, error) {
+	org, err := requireOwner(app, c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := findTeam(app, org, team)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := helpers.FindUser(app, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	members := record.GetStringSlice("members")
+	index := slices.Index(members, user.Id)
+
+	action := "org.team.member.add"
+	switch {
+	case present && index == -1:
+		members = append(members, user.Id)
+	case !present && index != -1:
+		members = slices.Delete(members, index, index+1)
+		action = "org.team.member.remove"
+	default:
+		return Get(app, name)
+	}
+
+	record.Set("members", members)
+	if err := app.Dao().SaveRecord(record); err != nil {
+		return nil, err
+	}
+
+	audit.Log(app, c, "@"+name, action, user.Id, map[string]any{"team": team})
+	return Get(app, name)
+}
@@ -3,6 +3,8 @@ package parse
 import (
 	"errors"
 	"regexp"
+	"strings"
+
 	"github.com/jxskiss/base62"
 )
 
@@ -23,3 +25,16 @@ func OriginalName(encoded_name string) string {
 		return string(decoded_name)
 	}
 }
+
+func Scope(package_name string) string {
+	if !strings.HasPrefix(package_name, "@") {
+		return ""
+	}
+
+	scope, _, found := strings.Cut(package_name[1:], ":")
+	if !found {
+		return ""
+	}
+
+	return scope
+}
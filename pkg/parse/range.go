@@ -0,0 +1,258 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// SplitVersion splits "name@version" into the package name and the version
+// or range. Scoped names start with an "@" that is not a separator.
+func SplitVersion(spec string) (string, string) {
+	if i := strings.LastIndex(spec, "@"); i > 0 {
+		return spec[:i], spec[i+1:]
+	}
+
+	return spec, ""
+}
+
+// IsExactVersion reports whether version pins a single release, as opposed
+// to a range like ^1 or ~1.2.
+func IsExactVersion(version string) bool {
+	return version != "" && HasSemVersion(version)
+}
+
+type comparator struct {
+	op      string
+	version string
+}
+
+// Satisfies reports whether version matches an npm style range. Ranges are
+// sets of comparators (>=1.2.0 <2) joined with ||, where each part can also
+// be a caret (^1.2), tilde (~1.2) or x-range (1.x, *). Prereleases only
+// match comparators on the same major.minor.patch.
+func Satisfies(version string, spec string) (bool, error) {
+	if !IsExactVersion(version) {
+		return false, fmt.Errorf("invalid version '%s'", version)
+	}
+
+	for _, set := range strings.Split(spec, "||") {
+		comparators, err := parseRange(set)
+		if err != nil {
+			return false, err
+		}
+
+		if matches(version, comparators) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ValidRange reports whether spec can be used with Satisfies.
+func ValidRange(spec string) bool {
+	for _, set := range strings.Split(spec, "||") {
+		if _, err := parseRange(set); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matches(version string, comparators []comparator) bool {
+	v := "v" + version
+
+	for _, c := range comparators {
+		result := semver.Compare(v, "v"+c.version)
+		ok := false
+		switch c.op {
+		case ">":
+			ok = result > 0
+		case ">=":
+			ok = result >= 0
+		case "<":
+			ok = result < 0
+		case "<=":
+			ok = result <= 0
+		default:
+			ok = result == 0
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	if semver.Prerelease(v) == "" {
+		return true
+	}
+
+	for _, c := range comparators {
+		if semver.Prerelease("v"+c.version) != "" && semver.Canonical(strings.TrimSuffix(v, semver.Prerelease(v))) == semver.Canonical(strings.TrimSuffix("v"+c.version, semver.Prerelease("v"+c.version))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseRange(set string) ([]comparator, error) {
+	comparators := []comparator{}
+
+	for _, part := range strings.Fields(set) {
+		switch {
+		case part == "*" || part == "x" || part == "X" || part == "latest":
+			comparators = append(comparators, comparator{">=", "0.0.0"})
+		case strings.HasPrefix(part, "^"):
+			lower, upper, err := caret(part[1:])
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, lower, upper)
+		case strings.HasPrefix(part, "~"):
+			lower, upper, err := tilde(strings.TrimPrefix(part[1:], ">"))
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, lower, upper)
+		default:
+			op := ""
+			for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+				if strings.HasPrefix(part, prefix) {
+					op = prefix
+					break
+				}
+			}
+
+			parsed, err := comparatorsFor(op, strings.TrimPrefix(strings.TrimPrefix(part, op), "v"))
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, parsed...)
+		}
+	}
+
+	if len(comparators) == 0 {
+		comparators = append(comparators, comparator{">=", "0.0.0"})
+	}
+
+	return comparators, nil
+}
+
+// partial parses versions like 1, 1.2, 1.x or 1.2.3-beta.1 and returns the
+// numeric parts that were given along with the full version they imply.
+func partial(version string) ([]int, string, error) {
+	version = strings.TrimPrefix(version, "v")
+	if IsExactVersion(version) {
+		parts := []int{0, 0, 0}
+		fmt.Sscanf(version, "%d.%d.%d", &parts[0], &parts[1], &parts[2])
+		return parts, version, nil
+	}
+
+	parts := []int{}
+	for _, field := range strings.Split(version, ".") {
+		if field == "x" || field == "X" || field == "*" {
+			break
+		}
+
+		var n int
+		if _, err := fmt.Sscanf(field, "%d", &n); err != nil || fmt.Sprint(n) != field || len(parts) == 3 {
+			return nil, "", fmt.Errorf("invalid version range '%s'", version)
+		}
+		parts = append(parts, n)
+	}
+
+	full := []int{0, 0, 0}
+	copy(full, parts)
+
+	return parts, fmt.Sprintf("%d.%d.%d", full[0], full[1], full[2]), nil
+}
+
+func next(parts []int, position int) string {
+	full := []int{0, 0, 0}
+	copy(full, parts)
+	full[position]++
+	for i := position + 1; i < 3; i++ {
+		full[i] = 0
+	}
+
+	return fmt.Sprintf("%d.%d.%d-0", full[0], full[1], full[2])
+}
+
+func comparatorsFor(op string, version string) ([]comparator, error) {
+	parts, full, err := partial(version)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts) == 3 || IsExactVersion(version) {
+		if op == "" {
+			op = "="
+		}
+		return []comparator{{op, full}}, nil
+	}
+
+	if len(parts) == 0 {
+		if op == "<" || op == ">" {
+			return []comparator{{"<", "0.0.0-0"}}, nil
+		}
+		return []comparator{{">=", "0.0.0"}}, nil
+	}
+
+	upper := next(parts, len(parts)-1)
+	switch op {
+	case ">":
+		return []comparator{{">=", upper}}, nil
+	case ">=":
+		return []comparator{{">=", full}}, nil
+	case "<":
+		return []comparator{{"<", full + "-0"}}, nil
+	case "<=":
+		return []comparator{{"<", upper}}, nil
+	default:
+		return []comparator{{">=", full}, {"<", upper}}, nil
+	}
+}
+
+func caret(version string) (comparator, comparator, error) {
+	parts, full, err := partial(version)
+	if err != nil {
+		return comparator{}, comparator{}, err
+	}
+
+	if len(parts) == 0 {
+		return comparator{">=", "0.0.0"}, comparator{">=", "0.0.0"}, nil
+	}
+
+	// the upper bound bumps the first non-zero part that was given
+	position := len(parts) - 1
+	for i, part := range parts {
+		if part != 0 || i == len(parts)-1 {
+			position = i
+			break
+		}
+	}
+
+	return comparator{">=", full}, comparator{"<", next(parts, position)}, nil
+}
+
+func tilde(version string) (comparator, comparator, error) {
+	parts, full, err := partial(version)
+	if err != nil {
+		return comparator{}, comparator{}, err
+	}
+
+	if len(parts) == 0 {
+		return comparator{">=", "0.0.0"}, comparator{">=", "0.0.0"}, nil
+	}
+
+	position := 1
+	if len(parts) == 1 {
+		position = 0
+	}
+
+	return comparator{">=", full}, comparator{"<", next(parts, position)}, nil
+}
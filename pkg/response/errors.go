@@ -2,9 +2,56 @@ package response
 
 import "registry/pkg/types"
 
+// Error codes let clients tell failures apart without parsing messages.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeTooLarge           = "too_large"
+	CodeInvalid            = "invalid"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeInternal           = "internal"
+	CodeUnsupportedTarget  = "unsupported_target"
+	CodeUnsupportedRuntime = "unsupported_runtime"
+	CodeLocalOnly          = "local_only"
+	CodeFileNotFound       = "file_not_found"
+	CodeBuildFailed        = "build_failed"
+)
+
+var codes = map[int64]string{
+	400: CodeBadRequest,
+	401: CodeUnauthorized,
+	403: CodeForbidden,
+	404: CodeNotFound,
+	409: CodeConflict,
+	413: CodeTooLarge,
+	422: CodeInvalid,
+	429: CodeTooManyRequests,
+}
+
+// Code returns the generic error code for a status.
+func Code(status int64) string {
+	if code, ok := codes[status]; ok {
+		return code
+	}
+
+	if status >= 400 && status < 500 {
+		return CodeBadRequest
+	}
+
+	return CodeInternal
+}
+
 func ErrorFromString(status int64, error string) types.Response {
+	return ErrorWithCode(status, Code(status), error)
+}
+
+func ErrorWithCode(status int64, code string, error string) types.Response {
 	return types.Response{Status: status, Message: map[string]interface{}{
 		"error": error,
+		"code":  code,
 	}}
 }
 
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"registry/pkg/auth"
+	"registry/pkg/parse"
+	"registry/pkg/response"
+	"registry/pkg/store"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+func VisiblePackage(app core.App, c echo.Context, packageName string) (*models.Record, error) {
+	pkg, err := store.FindPackage(app, packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	// private packages look the same as missing ones to other users
+	if !store.IsPublic(pkg) && !auth.CanRead(app, c, packageName) {
+		return nil, &store.NotFoundError{Package: packageName}
+	}
+
+	return pkg, nil
+}
+
+// VisibleVersion finds a version by its exact number or, for ranges like
+// ^1 or ~1.2, the highest version in the range. An empty version or
+// "latest" is the highest stable version.
+func VisibleVersion(app core.App, c echo.Context, packageName string, version string) (*models.Record, *models.Record, error) {
+	pkg, err := VisiblePackage(app, c, packageName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if version == "" || version == "latest" {
+		record, err := store.Latest(app, pkg)
+		return pkg, record, err
+	}
+
+	if !parse.IsExactVersion(version) {
+		record, err := store.Resolve(app, pkg, version)
+		return pkg, record, err
+	}
+
+	record, err := store.FindVersion(app, pkg, version)
+	return pkg, record, err
+}
+
+// LookupStatus is the status for an error of VisiblePackage or
+// VisibleVersion: 404 when the package or version does not exist and 500
+// when the lookup itself failed.
+func LookupStatus(err error) int {
+	if errors.Is(err, store.ErrNotFound) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// LookupError answers a failed lookup with a JSON error.
+func LookupError(c echo.Context, err error) error {
+	status := LookupStatus(err)
+	return c.JSON(status, response.ErrorFromString(int64(status), err.Error()))
+}
+
+// ImportError answers a failed lookup with a module that throws.
+func ImportError(c echo.Context, err error) error {
+	status := LookupStatus(err)
+	return ModuleError(c, status, response.Code(int64(status)), "ImportError: "+err.Error())
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"registry/pkg/parse"
+	"registry/pkg/store"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+const immutableAge = 31536000
+const shortAge = 300
+
+// Immutable marks a response whose content can never change for the URL,
+// like a file of a specific version. Private packages are only cached by
+// the client.
+func Immutable(c echo.Context, pkg *models.Record) {
+	c.Response().Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d, immutable", cacheScope(pkg), immutableAge))
+}
+
+// ShortLived marks a response that follows the latest version of a package
+// and has to be revalidated after a few minutes.
+func ShortLived(c echo.Context, pkg *models.Record) {
+	c.Response().Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", cacheScope(pkg), shortAge))
+}
+
+// Pinned caches a response for record forever only when the request named
+// its exact version. Latest and range requests can resolve to another
+// version once one is published, so they are short-lived and report the
+// version they resolved to.
+func Pinned(c echo.Context, pkg *models.Record, requested string, record *models.Record) {
+	if parse.IsExactVersion(requested) {
+		Immutable(c, pkg)
+		return
+	}
+
+	c.Response().Header().Set("X-Resolved-Version", record.GetString("version"))
+	ShortLived(c, pkg)
+}
+
+// Vary adds request headers that select between different responses for
+// the same URL.
+func Vary(c echo.Context, headers ...string) {
+	for _, header := range headers {
+		c.Response().Header().Add(echo.HeaderVary, header)
+	}
+}
+
+func cacheScope(pkg *models.Record) string {
+	if pkg != nil && !store.IsPublic(pkg) {
+		return "private"
+	}
+
+	return "public"
+}
+
+// Revalidate writes a short-lived response with an ETag derived from body
+// and a Last-Modified of modified, or a 304 when the client's copy is
+// still current.
+func Revalidate(c echo.Context, pkg *models.Record, modified time.Time, contentType string, body []byte) error {
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+
+	header := c.Response().Header()
+	ShortLived(c, pkg)
+	header.Set("ETag", etag)
+	if !modified.IsZero() {
+		header.Set(echo.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(c.Request(), etag, modified) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return c.Blob(http.StatusOK, contentType, body)
+}
+
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get(echo.HeaderIfModifiedSince))
+	return err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
+
+// LastModified returns the latest update time of the records.
+func LastModified(records ...*models.Record) time.Time {
+	latest := time.Time{}
+	for _, record := range records {
+		if updated := record.Updated.Time(); updated.After(latest) {
+			latest = updated
+		}
+	}
+
+	return latest
+}
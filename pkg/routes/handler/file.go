@@ -1,46 +1,75 @@
 package handler
 
 import (
-   "os"
+	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
 	"regexp"
 	"strings"
 
+	"registry/pkg/blob"
+	"registry/pkg/builds"
 	"registry/pkg/helpers"
+	"registry/pkg/just"
 	"registry/pkg/parse"
 	"registry/pkg/response"
+	"registry/pkg/stats"
 
-	"github.com/evanw/esbuild/pkg/api"
 	"github.com/labstack/echo/v5"
-	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/models"
 )
 
+// scriptEscaper keeps an encoded string from closing an inline script tag or
+// breaking older engines, which end string literals at line separators
+var scriptEscaper = strings.NewReplacer("</", `<\/`, "\u2028", `\u2028`, "\u2029", `\u2029`)
+
 func PackageError(info string) string {
+	// marshaling a string can't fail
+	encoded, _ := json.Marshal(info)
 	return fmt.Sprintf(`/* r.justjs.dev - error */
-throw new Error("[r.justjs.dev] " + "%s");
+throw new Error("[r.justjs.dev] " + %s);
 export default null;
-`, info)
+`, scriptEscaper.Replace(string(encoded)))
+}
+
+// ModuleError answers an import with status and a module that throws info,
+// so importers still get a readable error. The code is also sent in the
+// X-Error-Code header.
+func ModuleError(c echo.Context, status int, code string, info string) error {
+	c.Response().Header().Set("X-Error-Code", code)
+	return c.Blob(status, echo.MIMEApplicationJavaScriptCharsetUTF8, []byte(PackageError(info)))
 }
 
-func IndexFile(name string, version string, index string, defaultExport bool) string {
+func IndexFile(runtime string, name string, version string, target string, index string, defaultExport bool) string {
 	if defaultExport {
 		return fmt.Sprintf(`/* r.justjs.dev - %[2]s@%[3]s */
-export * from "/%[1]s/%[2]s/%[3]s/es2022/%s";
-export { default } from "/%[1]s/%[2]s/%[3]s/es2022/%s";
-`, os.Getenv("JUST_VERSION"), name, version, index)
+export * from "/%[1]s/%[2]s/%[3]s/%[4]s/%[5]s";
+export { default } from "/%[1]s/%[2]s/%[3]s/%[4]s/%[5]s";
+`, runtime, name, version, target, index)
 	} else {
 		return fmt.Sprintf(`/* r.justjs.dev - %[2]s@%[3]s */
-export * from "/%[1]s/%[2]s/%[3]s/es2022/%s";
-`, os.Getenv("JUST_VERSION"), name, version, index)
+export * from "/%[1]s/%[2]s/%[3]s/%[4]s/%[5]s";
+`, runtime, name, version, target, index)
+	}
+}
+
+func RequestedRuntime(c echo.Context) string {
+	if runtime := c.QueryParam("runtime"); runtime != "" {
+		return runtime
+	}
+
+	if runtime := c.Request().Header.Get("X-Just-Version"); runtime != "" {
+		return runtime
 	}
+
+	return just.Version()
 }
 
-func HasDefaultExport(record *models.Record) (bool, error) {
+func HasDefaultExport(app core.App, record *models.Record) (bool, error) {
 	hasExport := regexp.MustCompile(`export default | as default}`).MatchString
-	filePath := fmt.Sprintf("packages/storage/%s/%s", record.BaseFilesPath(), record.GetString("tarball"))
-	file, err := helpers.ReadFromTar(record.GetString("index"), filePath)
+	file, err := helpers.ReadFromTar(record.GetString("index"), blob.Tarball(app, record))
 	if err != nil {
 		return false, err
 	}
@@ -52,55 +81,64 @@ func HasDefaultExport(record *models.Record) (bool, error) {
 	return false, nil
 }
 
+// PinRedirects makes GetIndex redirect requests for the latest version or
+// a range to the URL of the version they resolve to, which can be cached
+// for good.
+var PinRedirects bool
+
 func GetIndex(app core.App, c echo.Context) error {
-	if parse.HasSemVersion(c.PathParam("package")) {
-		packageVersion := parse.GetSemVer(c.PathParam("package"))
-		packageName := strings.ReplaceAll(c.PathParam("package"), fmt.Sprintf("@%s", packageVersion), "")
-		encodedName, err := parse.EncodeName(packageName)
-		if err != nil {
-			return c.JSON(500, response.ErrorFromString(500, err.Error()))
-		}
+	runtime := RequestedRuntime(c)
+	options, ok := just.Lookup(runtime)
+	if !ok {
+		return ModuleError(c, 400, response.CodeUnsupportedRuntime, fmt.Sprintf("RuntimeError: just %s is not supported by this registry", runtime))
+	}
 
-		records, err := app.Dao().FindRecordsByExpr(encodedName, dbx.HashExp{"visibility": "public", "version": packageVersion})
-		if err != nil {
-			return c.JSON(500, response.ErrorFromString(500, err.Error()))
-		}
+	target := builds.Target(options)
+	if target == "" {
+		return ModuleError(c, 400, response.CodeUnsupportedTarget, fmt.Sprintf("BuildError: no build target is enabled for just %s", runtime))
+	}
 
-		record := records[0]
-		if record.GetString("group") == "local" {
-			return c.String(200, PackageError(fmt.Sprintf(`ImportError: %s@%s can only be used as local package`, packageName, packageVersion)))
-		}
+	packageName, packageVersion := parse.SplitVersion(c.PathParam("package"))
+	pkg, record, err := VisibleVersion(app, c, packageName, packageVersion)
+	if err != nil {
+		return ImportError(c, err)
+	}
 
-		defaultExport, err := HasDefaultExport(record)
-		if err != nil {
-			return c.JSON(500, response.ErrorFromString(500, err.Error()))
-		}
+	version := record.GetString("version")
+	if pkg.GetString("group") == "local" {
+		return ModuleError(c, 403, response.CodeLocalOnly, fmt.Sprintf(`ImportError: %s@%s can only be used as local package`, packageName, version))
+	}
 
-		return c.String(200, IndexFile(packageName, packageVersion, record.GetString("index"), defaultExport))
-	} else {
-		packageName := c.PathParam("package")
-		encodedName, err := parse.EncodeName(packageName)
-		if err != nil {
-			return c.JSON(500, response.ErrorFromString(500, err.Error()))
-		}
+	Vary(c, "X-Just-Version")
 
-		records, err := app.Dao().FindRecordsByExpr(encodedName, dbx.HashExp{"visibility": "public"})
-		if err != nil {
-			return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	if !parse.IsExactVersion(packageVersion) && PinRedirects {
+		location := fmt.Sprintf("/%s@%s", packageName, version)
+		if strings.HasSuffix(c.Request().URL.Path, "/mod") {
+			location += "/mod"
 		}
-
-		record := records[len(records)-1]
-		if record.GetString("group") == "local" {
-			return c.String(200, PackageError(fmt.Sprintf(`ImportError: %s@%s can only be used as local package`, packageName, record.GetString("version"))))
+		if query := c.Request().URL.RawQuery; query != "" {
+			location += "?" + query
 		}
 
-		defaultExport, err := HasDefaultExport(record)
-		if err != nil {
-			return c.JSON(500, response.ErrorFromString(500, err.Error()))
-		}
+		Pinned(c, pkg, packageVersion, record)
+		return c.Redirect(http.StatusFound, location)
+	}
 
-		return c.String(200, IndexFile(packageName, record.GetString("version"), record.GetString("index"), defaultExport))
+	defaultExport, err := HasDefaultExport(app, record)
+	if err != nil {
+		return ModuleError(c, 500, response.CodeInternal, err.Error())
 	}
+
+	stats.Record(c, packageName, version)
+	body := IndexFile(runtime, packageName, version, target, record.GetString("index"), defaultExport)
+
+	if parse.IsExactVersion(packageVersion) {
+		Immutable(c, pkg)
+		return c.Blob(200, echo.MIMEApplicationJavaScriptCharsetUTF8, []byte(body))
+	}
+
+	c.Response().Header().Set("X-Resolved-Version", version)
+	return Revalidate(c, pkg, LastModified(pkg, record), echo.MIMEApplicationJavaScriptCharsetUTF8, []byte(body))
 }
 
 func GetFile(app core.App, c echo.Context) error {
@@ -109,84 +147,68 @@ func GetFile(app core.App, c echo.Context) error {
 	esVersion := c.PathParam("esm")
 	fileName := c.PathParam("*")
 
-	var esTarget = api.DefaultTarget
-	switch esVersion {
-	case "es2022":
-		esTarget = api.ES2022
-	case "es2021":
-		esTarget = api.ES2021
-	case "es2020":
-		esTarget = api.ES2020
-	case "es2019":
-		esTarget = api.ES2019
-	case "es2018":
-		esTarget = api.ES2018
-	case "es2017":
-		esTarget = api.ES2017
-	case "es2016":
-		esTarget = api.ES2016
-	case "es2015":
-		esTarget = api.ES2015
-	case "es6":
-		esTarget = api.ES2015
-	default:
-		return c.String(200, PackageError(fmt.Sprintf("BuildError: target %s cannot be used for %s", esVersion, c.PathParam("package"))))
-	}
-
-	setMod := strings.NewReplacer(`from"./`, fmt.Sprintf(`from"/%s/%s/%s/%s/`, os.Getenv("JUST_VERSION"), packageName, packageVersion, esVersion))
+	if !builds.IsTarget(esVersion) {
+		return ModuleError(c, 400, response.CodeUnsupportedTarget, fmt.Sprintf("BuildError: target %s cannot be used for %s", esVersion, c.PathParam("package")))
+	}
+
+	runtime, _ := just.Lookup(c.PathParam("runtime"))
+	if !runtime.AllowsTarget(esVersion) {
+		return ModuleError(c, 400, response.CodeUnsupportedTarget, fmt.Sprintf("BuildError: target %s is not enabled for just %s", esVersion, c.PathParam("runtime")))
+	}
+
 	encodedName, err := parse.EncodeName(packageName)
 	if err != nil {
-		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+		return ModuleError(c, 400, response.CodeBadRequest, err.Error())
 	}
 
-	records, err := app.Dao().FindRecordsByExpr(encodedName, dbx.HashExp{"visibility": "public", "version": packageVersion})
+	pkg, record, err := VisibleVersion(app, c, packageName, packageVersion)
 	if err != nil {
-		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+		return ImportError(c, err)
 	}
 
-	record := records[len(records)-1]
-	filePath := fmt.Sprintf("packages/storage/%s/%s", record.BaseFilesPath(), record.GetString("tarball"))
-	banner := map[string]string{"js": fmt.Sprintf("/* r.justjs.dev - esbuild bundle(%s@%s) %s production */", packageName, packageVersion, esVersion)}
+	// relative imports point at the resolved version so a range request
+	// doesn't pull the rest of the package from whatever the range resolves
+	// to later
+	setMod := strings.NewReplacer(`from"./`, fmt.Sprintf(`from"/%s/%s/%s/%s/`, c.PathParam("runtime"), packageName, record.GetString("version"), esVersion))
+	variant := builds.Variant(esVersion, runtime)
 
-	file, err := helpers.ReadFromTar(fileName, filePath)
+	output, err := builds.Cached(app, record, fileName, variant)
 	if err != nil {
-		return c.String(200, PackageError(fmt.Sprintf("resovleESModule: open /vfs/%s/%s/%s/%s: no such file or directory", encodedName, packageName, packageVersion, fileName)))
-	}
-
-	contents := &api.StdinOptions{
-		Contents:   string(file),
-		Sourcefile: fileName,
-	}
-
-	loader := map[string]api.Loader{
-		".wasm":  api.LoaderDataURL,
-		".svg":   api.LoaderDataURL,
-		".png":   api.LoaderDataURL,
-		".webp":  api.LoaderDataURL,
-		".ttf":   api.LoaderDataURL,
-		".eot":   api.LoaderDataURL,
-		".woff":  api.LoaderDataURL,
-		".woff2": api.LoaderDataURL,
-	}
-
-	result := api.Build(api.BuildOptions{
-		Loader:            loader,
-		Stdin:             contents,
-		EntryPoints:       nil,
-		MinifyWhitespace:  true,
-		MinifyIdentifiers: true,
-		MinifySyntax:      true,
-		KeepNames:         true,
-		Write:             false,
-		Bundle:            false,
-		Banner:            banner,
-		Target:            esTarget,
-		Format:            api.FormatESModule,
-		LogLevel:          api.LogLevelSilent,
-		Platform:          api.PlatformBrowser,
-	})
-
-	return c.String(200, setMod.Replace(string(result.OutputFiles[0].Contents)))
+		file, err := helpers.ReadFromTar(fileName, blob.Tarball(app, record))
+		if err != nil {
+			return ModuleError(c, 404, response.CodeFileNotFound, fmt.Sprintf("resovleESModule: open /vfs/%s/%s/%s/%s: no such file or directory", encodedName, packageName, packageVersion, fileName))
+		}
+
+		result := builds.Transform(fileName, file, esVersion, runtime, builds.Banner(packageName, record.GetString("version"), esVersion))
+		if err := builds.Record(app, packageName, record.GetString("version"), fileName, variant, result); err != nil {
+			log.Printf("builds: unable to record build of %s@%s/%s: %v", packageName, record.GetString("version"), fileName, err)
+		}
+
+		if result.Failed() {
+			return BuildError(c, result)
+		}
+
+		output = result.Output
+		if err := builds.Store(app, record, fileName, variant, output); err != nil {
+			log.Printf("builds: unable to cache build of %s@%s/%s: %v", packageName, record.GetString("version"), fileName, err)
+		}
+	}
+
+	Pinned(c, pkg, packageVersion, record)
+	return c.Blob(200, echo.MIMEApplicationJavaScriptCharsetUTF8, []byte(setMod.Replace(string(output))))
+}
+
+// BuildError answers with the diagnostics of a failed build, as JSON for
+// clients asking for it and as a throwing module otherwise.
+func BuildError(c echo.Context, result *builds.Result) error {
+	if PrefersJSON(c) {
+		body := response.ErrorWithCode(422, response.CodeBuildFailed, result.Error())
+		body.Message["errors"] = result.Errors
+		body.Message["warnings"] = result.Warnings
+		return c.JSON(422, body)
+	}
+
+	return ModuleError(c, 422, response.CodeBuildFailed, "BuildError: "+result.Error())
 }
 
 func GetSource(app core.App, c echo.Context) error {
@@ -194,23 +216,20 @@ func GetSource(app core.App, c echo.Context) error {
    packageVersion := c.PathParam("version")
    fileName := c.PathParam("*")
 
-   encodedName, err := parse.EncodeName(packageName)
+   pkg, record, err := VisibleVersion(app, c, packageName, packageVersion)
    if err != nil {
-      return c.JSON(500, response.ErrorFromString(500, err.Error()))
+      return LookupError(c, err)
    }
 
-   records, err := app.Dao().FindRecordsByExpr(encodedName, dbx.HashExp{"visibility": "public", "version": packageVersion})
-   if err != nil {
-      return c.JSON(500, response.ErrorFromString(500, err.Error()))
+   if fileName == "" || strings.HasSuffix(fileName, "/") {
+      return BrowseSource(app, c, pkg, record, fileName)
    }
 
-   record := records[len(records)-1]
-   filePath := fmt.Sprintf("packages/storage/%s/%s", record.BaseFilesPath(), record.GetString("tarball"))
-
-   file, err := helpers.ReadFromTar(fileName, filePath)
+   file, err := helpers.ReadFromTar(fileName, blob.Tarball(app, record))
    if err != nil {
-      return c.JSON(404, response.ErrorFromString(404, "file does not exist"))
+      return BrowseSource(app, c, pkg, record, fileName+"/")
    }
 
+   Pinned(c, pkg, packageVersion, record)
    return c.String(200, string(file))
 }
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPackageError(t *testing.T) {
+	for _, info := range []string{
+		`package "pkg" not found`,
+		`</script><script>alert(1)</script>`,
+		"line\u2028separator\u2029paragraph",
+		`back\slash ${template} ` + "`tick`",
+		"\x00 control \n characters",
+	} {
+		module := PackageError(info)
+
+		for _, unsafe := range []string{"</", "\u2028", "\u2029"} {
+			if strings.Contains(module, unsafe) {
+				t.Errorf("PackageError(%q) contains %q:\n%s", info, unsafe, module)
+			}
+		}
+
+		start := strings.Index(module, `" + `) + len(`" + `)
+		end := strings.LastIndex(module, ");")
+		var decoded string
+		if err := json.Unmarshal([]byte(strings.ReplaceAll(module[start:end], `<\/`, `</`)), &decoded); err != nil {
+			t.Errorf("PackageError(%q) has an invalid literal: %v", info, err)
+		} else if decoded != info {
+			t.Errorf("PackageError(%q) throws %q", info, decoded)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"registry/pkg/blob"
+	"registry/pkg/helpers"
+	"registry/pkg/response"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+type FileList struct {
+	Package string             `json:"package"`
+	Version string             `json:"version"`
+	Files   []helpers.TarEntry `json:"files"`
+}
+
+type browseEntry struct {
+	Name string
+	Href string
+	Dir  bool
+	Size int64
+	Type string
+}
+
+type browseData struct {
+	Package string
+	Version string
+	Dir     string
+	Parent  string
+	Entries []browseEntry
+}
+
+var browsePage = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Package}}@{{.Version}}/{{.Dir}} - r.justjs.dev</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 60rem; margin: 0 auto; padding: 2rem 1rem; color: #1f2328; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: .25rem .5rem; border-bottom: 1px solid #d0d7de; }
+td.size { text-align: right; font-variant-numeric: tabular-nums; }
+</style>
+</head>
+<body>
+<h1><a href="/{{.Package}}@{{.Version}}">{{.Package}}@{{.Version}}</a> / {{.Dir}}</h1>
+<table>
+{{if .Dir}}<tr><td><a href="{{.Parent}}">../</a></td><td></td><td></td></tr>{{end}}
+{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}{{if .Dir}}/{{end}}</a></td><td>{{.Type}}</td><td class="size">{{if not .Dir}}{{.Size}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func ListFiles(app core.App, c echo.Context) error {
+	packageName := c.PathParam("package")
+
+	pkg, record, err := VisibleVersion(app, c, packageName, c.PathParam("version"))
+	if err != nil {
+		return LookupError(c, err)
+	}
+
+	files, err := helpers.ListTar(blob.Tarball(app, record))
+	if err != nil {
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+
+	Pinned(c, pkg, c.PathParam("version"), record)
+	return c.JSON(http.StatusOK, &FileList{
+		Package: packageName,
+		Version: record.GetString("version"),
+		Files:   files,
+	})
+}
+
+// BrowseSource lists the files and directories directly inside dir, which
+// is empty for the root of the tarball or ends with a slash. Trailing
+// slashes are removed from request paths before routing, so GetSource
+// falls back to this for any path that is not a file.
+func BrowseSource(app core.App, c echo.Context, pkg *models.Record, record *models.Record, dir string) error {
+	files, err := helpers.ListTar(blob.Tarball(app, record))
+	if err != nil {
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+
+	base := "/source/" + c.PathParam("package") + "/" + c.PathParam("version") + "/"
+	data := browseData{
+		Package: c.PathParam("package"),
+		Version: record.GetString("version"),
+		Dir:     dir,
+		Entries: []browseEntry{},
+	}
+
+	if dir != "" {
+		parent := strings.TrimSuffix(dir, "/")
+		if i := strings.LastIndex(parent, "/"); i >= 0 {
+			data.Parent = base + parent[:i+1]
+		} else {
+			data.Parent = base
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Path, dir) {
+			continue
+		}
+
+		name, _, nested := strings.Cut(strings.TrimPrefix(file.Path, dir), "/")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		entry := browseEntry{Name: name, Href: base + dir + name, Dir: nested}
+		if nested {
+			entry.Href += "/"
+		} else {
+			entry.Size = file.Size
+			entry.Type = file.ContentType
+		}
+
+		data.Entries = append(data.Entries, entry)
+	}
+
+	if len(data.Entries) == 0 && dir != "" {
+		return c.JSON(404, response.ErrorFromString(404, "file does not exist"))
+	}
+
+	sort.SliceStable(data.Entries, func(i, j int) bool {
+		if data.Entries[i].Dir != data.Entries[j].Dir {
+			return data.Entries[i].Dir
+		}
+
+		return data.Entries[i].Name < data.Entries[j].Name
+	})
+
+	var out bytes.Buffer
+	if err := browsePage.Execute(&out, data); err != nil {
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+
+	Pinned(c, pkg, c.PathParam("version"), record)
+	return c.HTMLBlob(http.StatusOK, out.Bytes())
+}
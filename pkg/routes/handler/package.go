@@ -3,37 +3,37 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
-   "strings"
 
+	"registry/pkg/blob"
 	"registry/pkg/helpers"
 	"registry/pkg/parse"
 	"registry/pkg/response"
+	"registry/pkg/store"
 	"registry/pkg/types"
 
 	"github.com/labstack/echo/v5"
-	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
 	pb_types "github.com/pocketbase/pocketbase/tools/types"
 )
 
 func PackageIndex(app core.App, c echo.Context) error {
-	package_name, err := parse.EncodeName(c.PathParam("package"))
+	pkg, err := VisiblePackage(app, c, c.PathParam("package"))
 	if err != nil {
-		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+		return LookupError(c, err)
 	}
 
-	collection, err := app.Dao().FindCollectionByNameOrId(package_name)
+	records, err := store.Versions(app, pkg)
 	if err != nil {
-		return c.JSON(404, response.ErrorFromString(404, "package not found"))
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+	if len(records) == 0 {
+		return LookupError(c, &store.NotFoundError{Package: pkg.GetString("name")})
 	}
 
-	records, err := app.Dao().FindRecordsByExpr(package_name, dbx.HashExp{"visibility": "public"})
+	latest, err := store.Latest(app, pkg)
 	if err != nil {
-		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+		return LookupError(c, err)
 	}
-
-	latest := records[len(records)-1]
 	original := records[0]
 
 	times := make(map[string]pb_types.DateTime)
@@ -41,33 +41,25 @@ func PackageIndex(app core.App, c echo.Context) error {
 
 	for _, record := range records {
 		dependencies := make(map[string]string)
-		filename := record.GetString("tarball")
-		filePath := record.BaseFilesPath() + "/" + filename
 
 		if err := json.Unmarshal([]byte(record.GetString("dependencies")), &dependencies); err != nil {
 			return c.JSON(500, response.ErrorFromString(500, err.Error()))
 		}
 
-		fs, err := app.NewFilesystem()
-		if err != nil {
-			return c.JSON(500, response.ErrorFromString(500, err.Error()))
-		}
-		defer fs.Close()
-
-		attribute, err := fs.Attributes(filePath)
+		attribute, err := blob.Tarball(app, record).Attributes()
 		if err != nil {
 			return c.JSON(500, response.ErrorFromString(500, err.Error()))
 		}
 
 		pkgs[record.GetString("version")] = types.VersionInfo{
 			Id:           record.Id,
-			Access:       record.GetStringSlice("access"),
+			Access:       pkg.GetStringSlice("access"),
 			Version:      record.GetString("version"),
 			Published:    record.Created,
-			Description:  record.GetString("description"),
+			Description:  pkg.GetString("description"),
 			Author:       record.GetString("author"),
-			License:      helpers.PackageHasLicense(record),
-			Private:      helpers.PackagePrivacyStatus(record),
+			License:      helpers.PackageHasLicense(pkg),
+			Private:      helpers.PackagePrivacyStatus(pkg),
 			Dependencies: dependencies,
 			Dist: types.DistInfo{
 				Version:   record.GetString("version"),
@@ -85,24 +77,15 @@ func PackageIndex(app core.App, c echo.Context) error {
 	times["created"] = original.Created
 	times["updated"] = latest.Updated
 
-	filename := latest.GetString("tarball")
-	filePath := latest.BaseFilesPath() + "/" + filename
-
-	fs, err := app.NewFilesystem()
-	if err != nil {
-		return c.JSON(500, response.ErrorFromString(500, err.Error()))
-	}
-	defer fs.Close()
-
-	attribute, err := fs.Attributes(filePath)
+	attribute, err := blob.Tarball(app, latest).Attributes()
 	if err != nil {
 		return c.JSON(500, response.ErrorFromString(500, err.Error()))
 	}
 
-	return c.JSON(http.StatusOK, &types.PackageInfo{
+	body, err := json.Marshal(&types.PackageInfo{
 		Name:        c.PathParam("package"),
-		Id:          collection.Id,
-		Description: latest.GetString("description"),
+		Id:          pkg.Id,
+		Description: pkg.GetString("description"),
 		Versions:    pkgs,
 		Times:       times,
 		Dist: types.DistInfo{
@@ -111,59 +94,53 @@ func PackageIndex(app core.App, c echo.Context) error {
 			Tarball:   fmt.Sprintf("%s/%s/_/%s.tgz", helpers.TarPath(), c.PathParam("package"), c.PathParam("package")),
 			Size:      attribute.Size,
 		},
-		License: latest.GetString("license"),
+		License: pkg.GetString("license"),
 	})
-}
-
-func PackageVersion(app core.App, c echo.Context) error {
-	packageVersion := parse.GetSemVer(c.PathParam("package"))   
-	packageName := strings.ReplaceAll(c.PathParam("package"), fmt.Sprintf("@%s", packageVersion), "")
-	dependencies := make(map[string]string)
-
-	encodedName, err := parse.EncodeName(packageName)
 	if err != nil {
 		return c.JSON(500, response.ErrorFromString(500, err.Error()))
 	}
 
-	records, err := app.Dao().FindRecordsByExpr(encodedName, dbx.HashExp{"visibility": "public", "version": packageVersion})
-	if len(records) == 0 {
-		return c.JSON(404, response.ErrorFromString(404, "package or version not found"))
-	}
+	return Revalidate(c, pkg, LastModified(append(records, pkg)...), echo.MIMEApplicationJSONCharsetUTF8, body)
+}
 
-	record := records[0]
-	filename := record.GetString("tarball")
-	filePath := record.BaseFilesPath() + "/" + filename
+func PackageVersion(app core.App, c echo.Context) error {
+	packageName, packageVersion := parse.SplitVersion(c.PathParam("package"))
+	dependencies := make(map[string]string)
 
-	if err := json.Unmarshal([]byte(records[0].GetString("dependencies")), &dependencies); err != nil {
-		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	pkg, record, err := VisibleVersion(app, c, packageName, packageVersion)
+	if err != nil {
+		return LookupError(c, err)
 	}
 
-	fs, err := app.NewFilesystem()
-	if err != nil {
+	if err := json.Unmarshal([]byte(record.GetString("dependencies")), &dependencies); err != nil {
 		return c.JSON(500, response.ErrorFromString(500, err.Error()))
 	}
-	defer fs.Close()
 
-	attribute, err := fs.Attributes(filePath)
+	attribute, err := blob.Tarball(app, record).Attributes()
 	if err != nil {
 		return c.JSON(500, response.ErrorFromString(500, err.Error()))
 	}
 
-	return c.JSON(http.StatusOK, &types.VersionInfo{
+	body, err := json.Marshal(&types.VersionInfo{
 		Id:           record.Id,
-		Access:       record.GetStringSlice("access"),
+		Access:       pkg.GetStringSlice("access"),
 		Version:      record.GetString("version"),
 		Published:    record.Created,
-		Description:  record.GetString("description"),
+		Description:  pkg.GetString("description"),
 		Author:       record.GetString("author"),
-		License:      helpers.PackageHasLicense(record),
-		Private:      helpers.PackagePrivacyStatus(record),
+		License:      helpers.PackageHasLicense(pkg),
+		Private:      helpers.PackagePrivacyStatus(pkg),
 		Dependencies: dependencies,
 		Dist: types.DistInfo{
 			Version:   record.GetString("version"),
 			Integrity: fmt.Sprintf("MD5_%x", attribute.MD5),
-			Tarball:   fmt.Sprintf("%s/%s/_/%s/%s.tgz", helpers.TarPath(), packageName, packageVersion, packageName),
+			Tarball:   fmt.Sprintf("%s/%s/_/%s/%s.tgz", helpers.TarPath(), packageName, record.GetString("version"), packageName),
 			Size:      attribute.Size,
 		},
 	})
+	if err != nil {
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+
+	return Revalidate(c, pkg, LastModified(pkg, record), echo.MIMEApplicationJSONCharsetUTF8, body)
 }
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime"
+	"strconv"
+	"strings"
+
+	"registry/pkg/helpers"
+	"registry/pkg/parse"
+	"registry/pkg/response"
+	"registry/pkg/store"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// the default renderer leaves out raw html and unsafe link destinations,
+// which is all the sanitizing published markdown needs
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+var page = template.Must(template.New("package").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}}@{{.Version}} - r.justjs.dev</title>
+{{if .Description}}<meta name="description" content="{{.Description}}">{{end}}
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; color: #1f2328; }
+main { display: flex; flex-wrap: wrap; gap: 2rem; max-width: 72rem; margin: 0 auto; padding: 2rem 1rem; }
+article { flex: 1 1 40rem; min-width: 0; }
+aside { flex: 0 1 20rem; }
+pre, code { background: #f6f8fa; border-radius: 4px; }
+pre { padding: .75rem; overflow-x: auto; }
+aside pre { white-space: pre-wrap; word-break: break-all; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #d0d7de; padding: .25rem .5rem; }
+img { max-width: 100%; }
+.keywords span { display: inline-block; background: #ddf4ff; border-radius: 1rem; padding: 0 .5rem; margin: 0 .25rem .25rem 0; }
+.versions { list-style: none; padding: 0; }
+</style>
+</head>
+<body>
+<main>
+<article>
+<h1>{{.Name}} <small>{{.Version}}</small></h1>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .Keywords}}<p class="keywords">{{range .Keywords}}<span>{{.}}</span>{{end}}</p>{{end}}
+{{if .Readme}}{{.Readme}}{{else}}<p><em>This package has no readme.</em></p>{{end}}
+{{if .Changelog}}<details><summary>Changelog</summary>{{.Changelog}}</details>{{end}}
+</article>
+<aside>
+{{if .Import}}<h3>Import</h3>
+<pre><code>import * as pkg from "{{.Import}}";</code></pre>{{end}}
+{{if .Tarball}}<h3>Install</h3>
+<pre><code>curl -LO {{.Tarball}}</code></pre>{{end}}
+{{if .License}}<h3>License</h3><p>{{.License}}</p>{{end}}
+{{if .Repository}}<h3>Repository</h3><p><a href="{{.Repository}}" rel="nofollow">{{.Repository}}</a></p>{{end}}
+{{if .Url}}<h3>Homepage</h3><p><a href="{{.Url}}" rel="nofollow">{{.Url}}</a></p>{{end}}
+<h3>Versions</h3>
+<ul class="versions">
+{{range .Versions}}<li><a href="/{{$.Name}}@{{.Version}}">{{.Version}}</a> <small>{{.Published}}</small></li>
+{{end}}</ul>
+</aside>
+</main>
+</body>
+</html>
+`))
+
+type pageVersion struct {
+	Version   string
+	Published string
+}
+
+type pageData struct {
+	Name        string
+	Version     string
+	Description string
+	Keywords    []string
+	License     string
+	Repository  string
+	Url         string
+	Import      string
+	Tarball     string
+	Readme      template.HTML
+	Changelog   template.HTML
+	Versions    []pageVersion
+}
+
+// PrefersHTML reports whether the Accept header ranks text/html above JSON,
+// which is what browsers send and API clients don't.
+func PrefersHTML(c echo.Context) bool {
+	quality := acceptQuality(c)
+	return quality["text/html"] > 0 && quality["text/html"] > quality["application/json"]
+}
+
+// PrefersJSON reports whether the Accept header asks for JSON explicitly,
+// rather than accepting anything like module imports do.
+func PrefersJSON(c echo.Context) bool {
+	quality := acceptQuality(c)
+	return quality["application/json"] > 0 && quality["application/json"] > quality["application/javascript"]
+}
+
+// acceptQuality returns the quality of each media type in the Accept
+// header. JSON and JavaScript fall back to the quality of */* and every
+// missing type is -1.
+func acceptQuality(c echo.Context) map[string]float64 {
+	explicit := map[string]float64{}
+	for _, part := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+
+		explicit[mediaType] = quality
+	}
+
+	anyQuality, ok := explicit["*/*"]
+	if !ok {
+		anyQuality = -1
+	}
+
+	// html is only served when it is asked for by name
+	qualities := map[string]float64{"text/html": -1}
+	for _, mediaType := range []string{"application/json", "application/javascript"} {
+		qualities[mediaType] = anyQuality
+	}
+
+	for mediaType, quality := range explicit {
+		qualities[mediaType] = quality
+	}
+
+	return qualities
+}
+
+func renderMarkdown(source string) (template.HTML, error) {
+	if source == "" {
+		return "", nil
+	}
+
+	var out bytes.Buffer
+	if err := markdown.Convert([]byte(source), &out); err != nil {
+		return "", err
+	}
+
+	return template.HTML(out.String()), nil
+}
+
+func PackagePage(app core.App, c echo.Context) error {
+	packageName, packageVersion := parse.SplitVersion(c.PathParam("package"))
+
+	pkg, record, err := VisibleVersion(app, c, packageName, packageVersion)
+	if err != nil {
+		status := LookupStatus(err)
+		return c.HTML(status, fmt.Sprintf("<h1>%d</h1><p>%s</p>", status, template.HTMLEscapeString(err.Error())))
+	}
+
+	records, err := store.Versions(app, pkg)
+	if err != nil {
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+
+	data := pageData{
+		Name:        packageName,
+		Version:     record.GetString("version"),
+		Description: pkg.GetString("description"),
+		Keywords:    pkg.GetStringSlice("keywords"),
+		License:     pkg.GetString("license"),
+		Repository:  pkg.GetString("repository"),
+		Url:         pkg.GetString("url"),
+	}
+
+	if pkg.GetString("group") != "local" {
+		data.Import = fmt.Sprintf("%s/%s@%s", helpers.TarPath(), packageName, data.Version)
+	}
+
+	if pkg.GetString("group") != "net" {
+		data.Tarball = fmt.Sprintf("%s/%s/_/%s/%s.tgz", helpers.TarPath(), packageName, data.Version, packageName)
+	}
+
+	for i := len(records) - 1; i >= 0; i-- {
+		data.Versions = append(data.Versions, pageVersion{
+			Version:   records[i].GetString("version"),
+			Published: records[i].Created.Time().Format("2006-01-02"),
+		})
+	}
+
+	if data.Readme, err = renderMarkdown(record.GetString("readme")); err != nil {
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+
+	if data.Changelog, err = renderMarkdown(record.GetString("changelog")); err != nil {
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+
+	var out bytes.Buffer
+	if err := page.Execute(&out, data); err != nil {
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+
+	return Revalidate(c, pkg, LastModified(append(records, pkg)...), echo.MIMETextHTMLCharsetUTF8, out.Bytes())
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"registry/pkg/blob"
+	"registry/pkg/response"
+	"registry/pkg/templates"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func GetTemplate(app core.App, c echo.Context) error {
+	archive := c.PathParam("*")
+	if !strings.HasSuffix(archive, ".zip") {
+		return c.JSON(404, response.ErrorFromString(404, "template not found"))
+	}
+
+	name, version, _ := strings.Cut(strings.TrimSuffix(archive, ".zip"), "@")
+	record, err := templates.FindPublished(app, name, version)
+	if err != nil {
+		return LookupError(c, err)
+	}
+
+	// the zip is built before anything is sent, so a broken tarball is
+	// answered with an error instead of a truncated archive
+	var zip bytes.Buffer
+	if _, err := templates.WriteZip(blob.Tarball(app, record), name, &zip); err != nil {
+		return c.JSON(500, response.ErrorFromString(500, err.Error()))
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s@%s.zip"`, name, record.GetString("version")))
+	return c.Blob(200, "application/zip", zip.Bytes())
+}
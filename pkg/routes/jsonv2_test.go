@@ -0,0 +1,7 @@
+//go:build goexperiment.jsonv2
+
+package routes
+
+func init() {
+	jsonv2 = true
+}
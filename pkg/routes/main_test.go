@@ -0,0 +1,51 @@
+package routes
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// jsonv2 is set when the tests are built with the encoding/json v2
+// experiment.
+var jsonv2 = false
+
+// TestMain reruns the tests without the encoding/json v2 experiment when
+// they were built with it. pocketbase decodes collection schemas through
+// an alias of the type being decoded, which v2 resolves back to the same
+// UnmarshalJSON method until the stack overflows, so the test app can't
+// load a single collection there.
+func TestMain(m *testing.M) {
+	if !jsonv2 {
+		os.Exit(m.Run())
+	}
+
+	dir, err := os.MkdirTemp("", "routes-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	binary := filepath.Join(dir, "routes.test")
+	build := exec.Command("go", "test", "-c", "-o", binary, ".")
+	build.Env = append(os.Environ(), "GOEXPERIMENT=nojsonv2")
+	build.Stdout, build.Stderr = os.Stdout, os.Stderr
+
+	code := 1
+	if err := build.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to build the tests without encoding/json v2: %v\n", err)
+	} else {
+		run := exec.Command(binary, os.Args[1:]...)
+		run.Stdin, run.Stdout, run.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := run.Run(); err == nil {
+			code = 0
+		} else if exit, ok := err.(*exec.ExitError); ok {
+			code = exit.ExitCode()
+		}
+	}
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -2,15 +2,30 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
+	"registry/pkg/auth"
+	"registry/pkg/blob"
+	"registry/pkg/builds"
 	"registry/pkg/create"
+	"registry/pkg/diff"
+	"registry/pkg/just"
+	"registry/pkg/maintainers"
+	"registry/pkg/orgs"
 	"registry/pkg/parse"
 	"registry/pkg/response"
 	"registry/pkg/routes/handler"
+	"registry/pkg/search"
+	"registry/pkg/stats"
+	"registry/pkg/store"
 	"registry/pkg/templates"
 	"registry/pkg/types"
 
@@ -20,12 +35,100 @@ import (
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/models"
-	"github.com/pocketbase/pocketbase/tools/search"
+	pb_search "github.com/pocketbase/pocketbase/tools/search"
+	"github.com/spf13/cobra"
 )
 
-func Router(app core.App) error {
+func Router(app core.App, rootCmd *cobra.Command) error {
+	rootCmd.PersistentFlags().BoolVar(
+		&handler.PinRedirects,
+		"pinRedirects",
+		false,
+		"redirect imports of the latest version or a version range to the url of the version they resolve to",
+	)
+
+	rootCmd.PersistentFlags().StringSliceVar(
+		&auth.TrustedProxies,
+		"trustedProxies",
+		nil,
+		"ips or cidrs of reverse proxies whose forwarded headers are used to check the ip allowlists of api tokens",
+	)
+
 	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
-		e.Router.GET("/api/:ver/templates/*", apis.StaticDirectoryHandler(os.DirFS(templates.Dir()), false))
+		if err := search.Ensure(app); err != nil {
+			return err
+		}
+
+		// api tokens are only accepted on the routes that check their
+		// scopes, the routes of pocketbase itself don't know about them
+		loadToken := auth.LoadToken(app)
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/:ver/templates/*",
+			Handler: func(c echo.Context) error {
+				if _, err := fs.Stat(os.DirFS(templates.Dir()), c.PathParam("*")); err == nil {
+					return apis.StaticDirectoryHandler(os.DirFS(templates.Dir()), false)(c)
+				}
+
+				return handler.GetTemplate(app, c)
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/:ver/templates",
+			Handler: func(c echo.Context) error {
+				catalog, err := templates.LoadCatalog(templates.Dir())
+				if err != nil {
+					return c.JSON(500, response.ErrorFromString(500, err.Error()))
+				}
+
+				published, err := templates.Published(app)
+				if err != nil {
+					return c.JSON(500, response.ErrorFromString(500, err.Error()))
+				}
+
+				for _, template := range published {
+					if catalog.Find(template.Name) == nil {
+						catalog.Templates = append(catalog.Templates, template)
+					}
+				}
+
+				if runtime := c.QueryParam("runtime"); runtime != "" {
+					for i := range catalog.Templates {
+						releases := []templates.Release{}
+						for _, release := range catalog.Templates[i].Releases {
+							if release.SupportsRuntime(runtime) {
+								releases = append(releases, release)
+							}
+						}
+						catalog.Templates[i].Releases = releases
+					}
+				}
+
+				return c.JSON(http.StatusOK, catalog)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/api/:ver/templates/refresh",
+			Handler: func(c echo.Context) error {
+				if err := templates.Refresh(); err != nil {
+					return c.JSON(500, response.ErrorFromString(500, err.Error()))
+				}
+
+				return c.JSON(http.StatusOK, &types.Response{Status: http.StatusOK, Message: map[string]interface{}{"refreshed": templates.Source()}})
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				apis.RequireAdminAuth(),
+			},
+		})
 
 		e.Router.AddRoute(echo.Route{
 			Method: http.MethodGet,
@@ -45,10 +148,16 @@ func Router(app core.App) error {
 				checkAgent := regexp.MustCompile(`Wget/|curl|^$`).MatchString
 				userAgent := useragent.Parse(c.Request().UserAgent()).String
 
+				handler.Vary(c, "Accept", "User-Agent")
+
+				if handler.PrefersHTML(c) {
+					return handler.PackagePage(app, c)
+				}
+
 				if checkAgent(userAgent) {
 					return handler.GetIndex(app, c)
 				} else {
-					if parse.HasSemVersion(c.PathParam("package")) {
+					if _, version := parse.SplitVersion(c.PathParam("package")); version != "" {
 						return handler.PackageVersion(app, c)
 					} else {
 						return handler.PackageIndex(app, c)
@@ -57,6 +166,7 @@ func Router(app core.App) error {
 			},
 			Middlewares: []echo.MiddlewareFunc{
 				apis.ActivityLogger(app),
+				loadToken,
 			},
 		})
 
@@ -68,6 +178,7 @@ func Router(app core.App) error {
 			},
 			Middlewares: []echo.MiddlewareFunc{
 				apis.ActivityLogger(app),
+				loadToken,
 			},
 		})
 
@@ -75,23 +186,16 @@ func Router(app core.App) error {
 			Method: http.MethodGet,
 			Path:   "/:name/_/:version/:archive",
 			Handler: func(c echo.Context) error {
-				fs, err := app.NewFilesystem()
-				package_name, _ := parse.EncodeName(c.PathParam("name"))
-				package_version := c.PathParam("version")
-				records, err := app.Dao().FindRecordsByExpr(package_name, dbx.HashExp{"visibility": "public", "version": package_version})
-				filePath := fmt.Sprintf("%s/%s", records[0].BaseFilesPath(), records[0].GetString("tarball"))
-				servedName := fmt.Sprintf("%s-%s.tgz", c.PathParam("name"), records[0].GetString("version"))
-
+				pkg, record, err := handler.VisibleVersion(app, c, c.PathParam("name"), c.PathParam("version"))
 				if err != nil {
-               return c.JSON(500, response.ErrorFromString(500, err.Error()))
+					return handler.LookupError(c, err)
 				}
 
-				if err != nil {
-               return c.JSON(500, response.ErrorFromString(500, err.Error()))
-				}
-				defer fs.Close()
+				servedName := fmt.Sprintf("%s-%s.tgz", c.PathParam("name"), record.GetString("version"))
+				stats.Record(c, c.PathParam("name"), record.GetString("version"))
+				handler.Pinned(c, pkg, c.PathParam("version"), record)
 
-				if err := fs.Serve(c.Response(), c.Request(), filePath, servedName); err != nil {
+				if err := blob.Tarball(app, record).Serve(c.Response(), c.Request(), servedName); err != nil {
                return c.JSON(500, response.ErrorFromString(500, err.Error()))
 				}
 
@@ -99,6 +203,7 @@ func Router(app core.App) error {
 			},
 			Middlewares: []echo.MiddlewareFunc{
 				apis.ActivityLogger(app),
+				loadToken,
 			},
 		})
 
@@ -106,22 +211,20 @@ func Router(app core.App) error {
 			Method: http.MethodGet,
 			Path:   "/:name/_/:archive",
 			Handler: func(c echo.Context) error {
-				fs, err := app.NewFilesystem()
-				package_name, _ := parse.EncodeName(c.PathParam("name"))
-				records, err := app.Dao().FindRecordsByExpr(package_name, dbx.HashExp{"visibility": "public"})
-				filePath := fmt.Sprintf("%s/%s", records[len(records)-1].BaseFilesPath(), records[len(records)-1].GetString("tarball"))
-				servedName := fmt.Sprintf("%s-%s.tgz", c.PathParam("name"), records[len(records)-1].GetString("version"))
-
+				pkg, record, err := handler.VisibleVersion(app, c, c.PathParam("name"), "")
 				if err != nil {
-               return c.JSON(500, response.ErrorFromString(500, err.Error()))
+					return handler.LookupError(c, err)
 				}
 
-				if err != nil {
-               return c.JSON(500, response.ErrorFromString(500, err.Error()))
-				}
-				defer fs.Close()
+				servedName := fmt.Sprintf("%s-%s.tgz", c.PathParam("name"), record.GetString("version"))
+				stats.Record(c, c.PathParam("name"), record.GetString("version"))
 
-				if err := fs.Serve(c.Response(), c.Request(), filePath, servedName); err != nil {
+				// the tarball id changes with every version, which makes it
+				// a cheap validator for the latest tarball
+				handler.ShortLived(c, pkg)
+				c.Response().Header().Set("ETag", fmt.Sprintf(`"%s"`, record.Id))
+
+				if err := blob.Tarball(app, record).Serve(c.Response(), c.Request(), servedName); err != nil {
                return c.JSON(500, response.ErrorFromString(500, err.Error()))
 				}
 
@@ -129,17 +232,23 @@ func Router(app core.App) error {
 			},
 			Middlewares: []echo.MiddlewareFunc{
 				apis.ActivityLogger(app),
+				loadToken,
 			},
 		})
 
 		e.Router.AddRoute(echo.Route{
 			Method: http.MethodGet,
-			Path:   fmt.Sprintf("/%s/:package/:version/:esm/*", os.Getenv("JUST_VERSION")),
+			Path:   "/:runtime/:package/:version/:esm/*",
 			Handler: func(c echo.Context) error {
+				if _, ok := just.Lookup(c.PathParam("runtime")); !ok {
+					return echo.ErrNotFound
+				}
+
 				return handler.GetFile(app, c)
 			},
 			Middlewares: []echo.MiddlewareFunc{
 				apis.ActivityLogger(app),
+				loadToken,
 			},
 		})
 
@@ -151,18 +260,101 @@ func Router(app core.App) error {
          },
          Middlewares: []echo.MiddlewareFunc{
             apis.ActivityLogger(app),
+            loadToken,
          },
       })
 
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/source/:package/:version",
+			Handler: func(c echo.Context) error {
+				return handler.GetSource(app, c)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/:ver/files/:package/:version",
+			Handler: func(c echo.Context) error {
+				return handler.ListFiles(app, c)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/:ver/diff/:package/:range",
+			Handler: func(c echo.Context) error {
+				from, to, found := strings.Cut(c.PathParam("range"), "...")
+				if !found || from == "" || to == "" {
+					return c.JSON(400, response.ErrorFromString(400, "expected a range like 1.0.0...1.1.0"))
+				}
+
+				pkg, fromRecord, err := handler.VisibleVersion(app, c, c.PathParam("package"), from)
+				if err != nil {
+					return handler.LookupError(c, err)
+				}
+
+				_, toRecord, err := handler.VisibleVersion(app, c, c.PathParam("package"), to)
+				if err != nil {
+					return handler.LookupError(c, err)
+				}
+
+				result, err := diff.Versions(app, pkg, fromRecord, toRecord)
+				if err != nil {
+					return c.JSON(500, response.ErrorFromString(500, err.Error()))
+				}
+
+				return c.JSON(http.StatusOK, result)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/:ver/builds/:package",
+			Handler: func(c echo.Context) error {
+				packageName := c.PathParam("package")
+				if _, err := handler.VisiblePackage(app, c, packageName); err != nil {
+					return handler.LookupError(c, err)
+				}
+
+				if !auth.HasRole(app, c, packageName, orgs.RoleMaintainer) {
+					return c.JSON(403, response.ErrorFromString(403, "only maintainers can see build reports"))
+				}
+
+				reports, err := builds.Reports(app, packageName, c.QueryParam("version"))
+				if err != nil {
+					return c.JSON(500, response.ErrorFromString(500, err.Error()))
+				}
+
+				return c.JSON(http.StatusOK, reports)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				apis.RequireRecordAuth("just_auth_system"),
+			},
+		})
+
 		e.Router.AddRoute(echo.Route{
 			Method: http.MethodPost,
 			Path:   "/api/:ver/create",
 			Handler: func(c echo.Context) error {
-				if err := create.Package(app, c); err != nil {
-					return c.JSON(500, response.ErrorFromString(500, err.Error()))
+				if !auth.HasScope(c, "publish:"+c.FormValue("name")) {
+					return c.JSON(403, response.ErrorFromString(403, fmt.Sprintf("api token is not allowed to publish '%s'", c.FormValue("name"))))
 				}
 
-				if err := create.Version(app, c); err != nil {
+				if err := create.Publish(app, c); err != nil {
 					return c.JSON(500, response.ErrorFromString(500, err.Error()))
 				}
 
@@ -170,27 +362,305 @@ func Router(app core.App) error {
 			},
 			Middlewares: []echo.MiddlewareFunc{
 				apis.ActivityLogger(app),
+				loadToken,
 				apis.RequireAdminOrRecordAuth("just_auth_system"),
 			},
 		})
 
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/:ver/runtime",
+			Handler: func(c echo.Context) error {
+				return c.JSON(http.StatusOK, just.Current())
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				apis.RequireAdminAuth(),
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/:ver/tokens",
+			Handler: func(c echo.Context) error {
+				user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+				tokens, err := auth.ListTokens(app, user)
+				if err != nil {
+					return c.JSON(500, response.ErrorFromString(500, err.Error()))
+				}
+
+				return c.JSON(http.StatusOK, tokens)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+				apis.RequireRecordAuth("just_auth_system"),
+				auth.RequireScope("admin"),
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/api/:ver/tokens",
+			Handler: func(c echo.Context) error {
+				user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+				req := auth.TokenRequest{}
+				if err := c.Bind(&req); err != nil {
+					return c.JSON(400, response.ErrorFromString(400, err.Error()))
+				}
+
+				token, info, err := auth.CreateToken(app, user, req)
+				if err != nil {
+					return c.JSON(400, response.ErrorFromString(400, err.Error()))
+				}
+
+				return c.JSON(http.StatusOK, map[string]interface{}{"token": token, "info": info})
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+				apis.RequireRecordAuth("just_auth_system"),
+				auth.RequireScope("admin"),
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodDelete,
+			Path:   "/api/:ver/tokens/:id",
+			Handler: func(c echo.Context) error {
+				user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+				if err := auth.RevokeToken(app, user, c.PathParam("id")); err != nil {
+					return c.JSON(404, response.ErrorFromString(404, err.Error()))
+				}
+
+				return c.JSON(http.StatusOK, &types.Response{Status: http.StatusOK, Message: map[string]interface{}{"revoked": c.PathParam("id")}})
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+				apis.RequireRecordAuth("just_auth_system"),
+				auth.RequireScope("admin"),
+			},
+		})
+
 		e.Router.AddRoute(echo.Route{
 			Method: http.MethodGet,
 			Path:   "/maintainers/:name",
 			Handler: func(c echo.Context) error {
-				encoded_name, _ := parse.EncodeName(c.PathParam("name"))
-				records, err := app.Dao().FindRecordsByExpr(encoded_name, dbx.HashExp{"visibility": "public"})
+				pkg, err := handler.VisiblePackage(app, c, c.PathParam("name"))
 				if err != nil {
-					return c.JSON(404, response.ErrorFromString(404, "package not found"))
+					return handler.LookupError(c, err)
 				}
 
 				if c.QueryParam("type") == "expanded" {
-					apis.EnrichRecord(c, app.Dao(), records[len(records)-1], "access")
-					return c.JSON(http.StatusOK, records[len(records)-1].Expand())
+					apis.EnrichRecord(c, app.Dao(), pkg, "access")
+					return c.JSON(http.StatusOK, pkg.Expand())
 				} else {
-					return c.JSON(http.StatusOK, records[len(records)-1].GetStringSlice("access"))
+					return c.JSON(http.StatusOK, pkg.GetStringSlice("access"))
 				}
 			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+			},
+		})
+
+		maintainerError := func(c echo.Context, err error) error {
+			switch {
+			case errors.Is(err, maintainers.ErrNotFound), errors.Is(err, maintainers.ErrNoInvite):
+				return c.JSON(404, response.ErrorFromString(404, err.Error()))
+			case errors.Is(err, maintainers.ErrForbidden), errors.Is(err, maintainers.ErrOwnerOnly):
+				return c.JSON(403, response.ErrorFromString(403, err.Error()))
+			default:
+				return c.JSON(400, response.ErrorFromString(400, err.Error()))
+			}
+		}
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/maintainers/:name",
+			Handler: func(c echo.Context) error {
+				invite, err := maintainers.Add(app, c, c.PathParam("name"), c.FormValue("user"))
+				if err != nil {
+					return maintainerError(c, err)
+				}
+
+				return c.JSON(http.StatusOK, invite)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+				apis.RequireAdminOrRecordAuth("just_auth_system"),
+				auth.RequireScope("admin"),
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/maintainers/:name/invites",
+			Handler: func(c echo.Context) error {
+				invites, err := maintainers.Invites(app, c, c.PathParam("name"))
+				if err != nil {
+					return maintainerError(c, err)
+				}
+
+				return c.JSON(http.StatusOK, invites)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+				apis.RequireAdminOrRecordAuth("just_auth_system"),
+				auth.RequireScope("admin"),
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/maintainers/:name/accept",
+			Handler: func(c echo.Context) error {
+				access, err := maintainers.Accept(app, c, c.PathParam("name"))
+				if err != nil {
+					return maintainerError(c, err)
+				}
+
+				return c.JSON(http.StatusOK, access)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+				apis.RequireRecordAuth("just_auth_system"),
+				auth.RequireScope("admin"),
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/maintainers/:name/decline",
+			Handler: func(c echo.Context) error {
+				if err := maintainers.Decline(app, c, c.PathParam("name"), c.FormValue("user")); err != nil {
+					return maintainerError(c, err)
+				}
+
+				return c.JSON(http.StatusOK, &types.Response{Status: http.StatusOK, Message: map[string]interface{}{"declined": c.PathParam("name")}})
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+				apis.RequireAdminOrRecordAuth("just_auth_system"),
+				auth.RequireScope("admin"),
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodDelete,
+			Path:   "/maintainers/:name/:user",
+			Handler: func(c echo.Context) error {
+				access, err := maintainers.Remove(app, c, c.PathParam("name"), c.PathParam("user"))
+				if err != nil {
+					return maintainerError(c, err)
+				}
+
+				return c.JSON(http.StatusOK, access)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+				apis.RequireAdminOrRecordAuth("just_auth_system"),
+				auth.RequireScope("admin"),
+			},
+		})
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/maintainers/:name/owner",
+			Handler: func(c echo.Context) error {
+				access, err := maintainers.Transfer(app, c, c.PathParam("name"), c.FormValue("user"))
+				if err != nil {
+					return maintainerError(c, err)
+				}
+
+				return c.JSON(http.StatusOK, access)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+				apis.RequireAdminOrRecordAuth("just_auth_system"),
+				auth.RequireScope("admin"),
+			},
+		})
+
+		orgError := func(c echo.Context, err error) error {
+			switch {
+			case errors.Is(err, orgs.ErrNotFound):
+				return c.JSON(404, response.ErrorFromString(404, err.Error()))
+			case errors.Is(err, orgs.ErrForbidden):
+				return c.JSON(403, response.ErrorFromString(403, err.Error()))
+			default:
+				return c.JSON(400, response.ErrorFromString(400, err.Error()))
+			}
+		}
+
+		orgRoutes := []struct {
+			method string
+			path   string
+			action func(c echo.Context) (*orgs.Org, error)
+		}{
+			{http.MethodPost, "/api/:ver/orgs", func(c echo.Context) (*orgs.Org, error) {
+				return orgs.Create(app, c, c.FormValue("name"), c.FormValue("description"))
+			}},
+			{http.MethodPost, "/api/:ver/orgs/:org/members", func(c echo.Context) (*orgs.Org, error) {
+				return orgs.SetMember(app, c, c.PathParam("org"), c.FormValue("user"), c.FormValue("role"))
+			}},
+			{http.MethodDelete, "/api/:ver/orgs/:org/members/:user", func(c echo.Context) (*orgs.Org, error) {
+				return orgs.RemoveMember(app, c, c.PathParam("org"), c.PathParam("user"))
+			}},
+			{http.MethodPost, "/api/:ver/orgs/:org/teams", func(c echo.Context) (*orgs.Org, error) {
+				return orgs.SetTeam(app, c, c.PathParam("org"), c.FormValue("name"), c.FormValue("role"))
+			}},
+			{http.MethodDelete, "/api/:ver/orgs/:org/teams/:team", func(c echo.Context) (*orgs.Org, error) {
+				return orgs.RemoveTeam(app, c, c.PathParam("org"), c.PathParam("team"))
+			}},
+			{http.MethodPost, "/api/:ver/orgs/:org/teams/:team/members", func(c echo.Context) (*orgs.Org, error) {
+				return orgs.SetTeamMember(app, c, c.PathParam("org"), c.PathParam("team"), c.FormValue("user"), true)
+			}},
+			{http.MethodDelete, "/api/:ver/orgs/:org/teams/:team/members/:user", func(c echo.Context) (*orgs.Org, error) {
+				return orgs.SetTeamMember(app, c, c.PathParam("org"), c.PathParam("team"), c.PathParam("user"), false)
+			}},
+		}
+
+		for _, route := range orgRoutes {
+			action := route.action
+			e.Router.AddRoute(echo.Route{
+				Method: route.method,
+				Path:   route.path,
+				Handler: func(c echo.Context) error {
+					org, err := action(c)
+					if err != nil {
+						return orgError(c, err)
+					}
+
+					return c.JSON(http.StatusOK, org)
+				},
+				Middlewares: []echo.MiddlewareFunc{
+					apis.ActivityLogger(app),
+					loadToken,
+					apis.RequireAdminOrRecordAuth("just_auth_system"),
+					auth.RequireScope("admin"),
+				},
+			})
+		}
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/:ver/orgs/:org",
+			Handler: func(c echo.Context) error {
+				org, err := orgs.View(app, c, c.PathParam("org"))
+				if err != nil {
+					return orgError(c, err)
+				}
+
+				return c.JSON(http.StatusOK, org)
+			},
 			Middlewares: []echo.MiddlewareFunc{
 				apis.ActivityLogger(app),
 			},
@@ -200,12 +670,14 @@ func Router(app core.App) error {
 			Method: http.MethodGet,
 			Path:   "/api/:ver/dependencies/:name",
 			Handler: func(c echo.Context) error {
-				encoded_name, _ := parse.EncodeName(c.PathParam("name"))
-				records, err := app.Dao().FindRecordsByExpr(encoded_name, dbx.HashExp{"visibility": "public"})
+				pkg, err := handler.VisiblePackage(app, c, c.PathParam("name"))
 				if err != nil {
-					return c.JSON(http.StatusNotFound, &types.Response{Status: http.StatusNotFound, Message: map[string]interface{}{
-						"error": "package or file not found",
-					}})
+					return handler.LookupError(c, err)
+				}
+
+				records, err := store.Versions(app, pkg)
+				if err != nil {
+					return c.JSON(500, response.ErrorFromString(500, err.Error()))
 				}
 
 				packages := make(map[string][]string)
@@ -214,17 +686,100 @@ func Router(app core.App) error {
 					urls := []string{}
 
 					_ = json.Unmarshal([]byte(record.GetString("dependencies")), &dep_list)
-					for dep_name := range dep_list {
-						dep, err := app.Dao().FindRecordsByExpr(dep_name, dbx.HashExp{"visibility": "public"})
-						if err == nil {
-							urls = append(urls, fmt.Sprintf("https://r.justjs.dev/%s/_/%s/%s.tgz", dep_name, dep[0].GetString("version"), dep_name))
+					for dep_name, dep_range := range dep_list {
+						// dependencies that can't be resolved are left out
+						// like missing ones
+						_, dep, err := handler.VisibleVersion(app, c, dep_name, dep_range)
+						if err != nil {
+							continue
 						}
+
+						urls = append(urls, fmt.Sprintf("https://r.justjs.dev/%s/_/%s/%s.tgz", dep_name, dep.GetString("version"), dep_name))
 					}
+					sort.Strings(urls)
 					packages[record.GetString("version")] = urls
 				}
 
 				return c.JSON(http.StatusOK, packages)
 			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.ActivityLogger(app),
+				loadToken,
+			},
+		})
+
+		downloads := func(c echo.Context, daily bool) error {
+			packageName := c.PathParam("package")
+			if packageName != "" {
+				if _, err := handler.VisiblePackage(app, c, packageName); err != nil {
+					return handler.LookupError(c, err)
+				}
+			}
+
+			var result any
+			var err error
+			if daily {
+				result, err = stats.DailyDownloads(app, packageName, c.QueryParam("version"), c.PathParam("period"))
+			} else {
+				result, err = stats.Downloads(app, packageName, c.QueryParam("version"), c.PathParam("period"))
+			}
+
+			if err != nil {
+				return c.JSON(400, response.ErrorFromString(400, err.Error()))
+			}
+
+			return c.JSON(http.StatusOK, result)
+		}
+
+		for _, path := range []string{"/api/:ver/downloads/point/:period", "/api/:ver/downloads/point/:period/:package"} {
+			e.Router.AddRoute(echo.Route{
+				Method: http.MethodGet,
+				Path:   path,
+				Handler: func(c echo.Context) error {
+					return downloads(c, false)
+				},
+				Middlewares: []echo.MiddlewareFunc{
+					apis.ActivityLogger(app),
+					loadToken,
+				},
+			})
+		}
+
+		for _, path := range []string{"/api/:ver/downloads/range/:period", "/api/:ver/downloads/range/:period/:package"} {
+			e.Router.AddRoute(echo.Route{
+				Method: http.MethodGet,
+				Path:   path,
+				Handler: func(c echo.Context) error {
+					return downloads(c, true)
+				},
+				Middlewares: []echo.MiddlewareFunc{
+					apis.ActivityLogger(app),
+					loadToken,
+				},
+			})
+		}
+
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/:ver/search",
+			Handler: func(c echo.Context) error {
+				page, _ := strconv.Atoi(c.QueryParam("page"))
+				perPage, _ := strconv.Atoi(c.QueryParam("perPage"))
+
+				result, err := search.Search(app, search.Options{
+					Query:   c.QueryParam("q"),
+					License: c.QueryParam("license"),
+					Group:   c.QueryParam("group"),
+					Types:   c.QueryParam("types"),
+					Page:    page,
+					PerPage: perPage,
+				})
+				if err != nil {
+					return c.JSON(500, response.ErrorFromString(500, err.Error()))
+				}
+
+				return c.JSON(http.StatusOK, result)
+			},
 			Middlewares: []echo.MiddlewareFunc{
 				apis.ActivityLogger(app),
 			},
@@ -234,28 +789,34 @@ func Router(app core.App) error {
 			Method: http.MethodGet,
 			Path:   "/packages",
 			Handler: func(c echo.Context) error {
-				fieldResolver := search.NewSimpleFieldResolver(
-					"id", "created", "updated", "name", "system", "type",
+				fieldResolver := pb_search.NewSimpleFieldResolver(
+					"id", "created", "updated", "name", "type", "visibility",
 				)
 
-				collections := []*models.Collection{}
+				collection, err := store.EnsurePackages(app.Dao())
+				if err != nil {
+					return c.JSON(500, response.ErrorFromString(500, err.Error()))
+				}
+
+				rows := []dbx.NullStringMap{}
 				pkgs := make(map[string]interface{})
 
-				result, err := search.NewProvider(fieldResolver).
-					Query(app.Dao().CollectionQuery()).
-					Filter([]search.FilterData{"id!='_pb_users_auth_'"}).
-					ParseAndExec(c.QueryString(), &collections)
+				result, err := pb_search.NewProvider(fieldResolver).
+					Query(app.Dao().RecordQuery(collection)).
+					Filter([]pb_search.FilterData{"visibility='public'"}).
+					ParseAndExec(c.QueryString(), &rows)
 
 				if err != nil {
 					return c.JSON(500, response.ErrorFromString(500, err.Error()))
 				}
 
-				for _, collection := range collections {
-					pkgs[parse.OriginalName(collection.Name)] = map[string]interface{}{
-						"id":      collection.Id,
-						"b62":     collection.Name,
-						"created": collection.Created,
-						"updated": collection.Updated,
+				for _, record := range models.NewRecordsFromNullStringMaps(collection, rows) {
+					b62, _ := parse.EncodeName(record.GetString("name"))
+					pkgs[record.GetString("name")] = map[string]interface{}{
+						"id":      record.Id,
+						"b62":     b62,
+						"created": record.Created,
+						"updated": record.Updated,
 					}
 				}
 
@@ -0,0 +1,332 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"registry/pkg/just"
+	"registry/pkg/response"
+	"registry/pkg/store"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/spf13/cobra"
+)
+
+// newTestServer serves the routes of the registry from a test app with a
+// public package "known" at 1.0.0 and 1.1.0, a public template "starter"
+// and a private package "secret" depending on known@^1.0.0, both at 1.0.0,
+// and returns the auth token of their maintainer.
+func newTestServer(t *testing.T) (*tests.TestApp, *echo.Echo, string) {
+	app, err := tests.NewTestApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Cleanup)
+
+	users := &models.Collection{Name: "just_auth_system", Type: models.CollectionTypeAuth}
+	users.SetOptions(models.CollectionAuthOptions{AllowUsernameAuth: true, MinPasswordLength: 8})
+	if err := app.Dao().SaveCollection(users); err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.NewRecord(users)
+	user.SetUsername("alice")
+	user.SetPassword("1234567890")
+	if err := app.Dao().SaveRecord(user); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := tokens.NewRecordAuthToken(app, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := store.EnsureVersions(app.Dao())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fixture := range []struct {
+		name         string
+		kind         string
+		visibility   string
+		versions     []string
+		dependencies string
+	}{
+		{"known", "package", "public", []string{"1.0.0", "1.1.0"}, ""},
+		{"starter", "template", "public", []string{"1.0.0"}, ""},
+		{"secret", "package", "private", []string{"1.0.0"}, `{"known":"^1.0.0"}`},
+	} {
+		pkg, err := store.SavePackage(app, fixture.name, map[string]any{"access": []string{user.Id}, "visibility": fixture.visibility, "type": fixture.kind})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, number := range fixture.versions {
+			version := models.NewRecord(versions)
+			version.Set("package", pkg.Id)
+			version.Set("version", number)
+			version.Set("index", "index.js")
+			version.Set("dependencies", fixture.dependencies)
+			if err := app.Dao().SaveRecord(version); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cmd := &cobra.Command{}
+	just.Register(app, cmd)
+	if err := Router(app, cmd); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.PersistentFlags().Parse([]string{"--justVersion", "v010"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := apis.InitApi(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app, e, token
+}
+
+type lookupCase struct {
+	name        string
+	path        string
+	auth        bool
+	status      int
+	contentType string
+	code        string
+}
+
+func (tc lookupCase) run(t *testing.T, e *echo.Echo, token string) {
+	req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+	if tc.auth {
+		req.Header.Set("Authorization", token)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != tc.status {
+		t.Fatalf("GET %s: status = %d, want %d\n%s", tc.path, rec.Code, tc.status, rec.Body.String())
+	}
+
+	contentType := rec.Header().Get(echo.HeaderContentType)
+	if !strings.HasPrefix(contentType, tc.contentType) {
+		t.Errorf("GET %s: Content-Type = %q, want %s", tc.path, contentType, tc.contentType)
+	}
+
+	code := rec.Header().Get("X-Error-Code")
+	if tc.contentType == echo.MIMEApplicationJSON {
+		body := struct {
+			Message struct {
+				Code string `json:"code"`
+			} `json:"message"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("GET %s: %v\n%s", tc.path, err, rec.Body.String())
+		}
+		code = body.Message.Code
+	}
+
+	if code != tc.code {
+		t.Errorf("GET %s: code = %q, want %q", tc.path, code, tc.code)
+	}
+}
+
+func TestUnknownPackage(t *testing.T) {
+	_, e, token := newTestServer(t)
+
+	js := echo.MIMEApplicationJavaScript
+	jsonType := echo.MIMEApplicationJSON
+
+	for _, tc := range []lookupCase{
+		{name: "module", path: "/unknown/mod", contentType: js},
+		{name: "module version", path: "/unknown@1.0.0/mod", contentType: js},
+		{name: "file", path: "/v010/unknown/1.0.0/es2022/index.js", contentType: js},
+		{name: "source", path: "/source/unknown/1.0.0/index.js", contentType: jsonType},
+		{name: "source listing", path: "/source/unknown/1.0.0", contentType: jsonType},
+		{name: "archive", path: "/unknown/_/1.0.0/unknown.tgz", contentType: jsonType},
+		{name: "latest archive", path: "/unknown/_/unknown.tgz", contentType: jsonType},
+		{name: "files", path: "/api/v1/files/unknown/1.0.0", contentType: jsonType},
+		{name: "diff", path: "/api/v1/diff/unknown/1.0.0...1.1.0", contentType: jsonType},
+		{name: "downloads", path: "/api/v1/downloads/point/last-week/unknown", contentType: jsonType},
+		{name: "daily downloads", path: "/api/v1/downloads/range/last-week/unknown", contentType: jsonType},
+		{name: "builds", path: "/api/v1/builds/unknown", auth: true, contentType: jsonType},
+		{name: "maintainers", path: "/maintainers/unknown", contentType: jsonType},
+		{name: "dependencies", path: "/api/v1/dependencies/unknown", contentType: jsonType},
+		{name: "templates", path: "/api/v1/templates/unknown.zip", contentType: jsonType},
+		{name: "package as template", path: "/api/v1/templates/known.zip", contentType: jsonType},
+	} {
+		tc.status, tc.code = http.StatusNotFound, response.CodeNotFound
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, e, token)
+		})
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+	_, e, token := newTestServer(t)
+
+	js := echo.MIMEApplicationJavaScript
+	jsonType := echo.MIMEApplicationJSON
+
+	for _, tc := range []lookupCase{
+		{name: "module", path: "/known@9.9.9/mod", contentType: js},
+		{name: "module range", path: "/known@^9/mod", contentType: js},
+		{name: "file", path: "/v010/known/9.9.9/es2022/index.js", contentType: js},
+		{name: "source", path: "/source/known/9.9.9/index.js", contentType: jsonType},
+		{name: "source listing", path: "/source/known/9.9.9", contentType: jsonType},
+		{name: "archive", path: "/known/_/9.9.9/known.tgz", contentType: jsonType},
+		{name: "files", path: "/api/v1/files/known/9.9.9", contentType: jsonType},
+		{name: "diff from", path: "/api/v1/diff/known/9.9.9...1.0.0", contentType: jsonType},
+		{name: "diff to", path: "/api/v1/diff/known/1.0.0...9.9.9", contentType: jsonType},
+		{name: "templates", path: "/api/v1/templates/starter@9.9.9.zip", contentType: jsonType},
+	} {
+		tc.status, tc.code = http.StatusNotFound, response.CodeNotFound
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, e, token)
+		})
+	}
+}
+
+// Lookups that fail, here because the packages table is gone, must not look
+// like a missing package.
+func TestLookupFailure(t *testing.T) {
+	app, e, token := newTestServer(t)
+	if _, err := app.Dao().DB().NewQuery("DROP TABLE " + store.PackagesCollection).Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	js := echo.MIMEApplicationJavaScript
+	jsonType := echo.MIMEApplicationJSON
+
+	for _, tc := range []lookupCase{
+		{name: "module", path: "/known/mod", contentType: js},
+		{name: "file", path: "/v010/known/1.0.0/es2022/index.js", contentType: js},
+		{name: "source", path: "/source/known/1.0.0/index.js", contentType: jsonType},
+		{name: "archive", path: "/known/_/1.0.0/known.tgz", contentType: jsonType},
+		{name: "files", path: "/api/v1/files/known/1.0.0", contentType: jsonType},
+		{name: "diff", path: "/api/v1/diff/known/1.0.0...1.1.0", contentType: jsonType},
+		{name: "downloads", path: "/api/v1/downloads/point/last-week/known", contentType: jsonType},
+		{name: "builds", path: "/api/v1/builds/known", auth: true, contentType: jsonType},
+		{name: "maintainers", path: "/maintainers/known", contentType: jsonType},
+		{name: "dependencies", path: "/api/v1/dependencies/known", contentType: jsonType},
+		{name: "templates", path: "/api/v1/templates/known.zip", contentType: jsonType},
+	} {
+		tc.status, tc.code = http.StatusInternalServerError, response.CodeInternal
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, e, token)
+		})
+	}
+}
+
+func TestPrivatePackage(t *testing.T) {
+	_, e, token := newTestServer(t)
+
+	for _, tc := range []lookupCase{
+		{name: "maintainers", path: "/maintainers/secret"},
+		{name: "dependencies", path: "/api/v1/dependencies/secret"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.contentType, tc.status, tc.code = echo.MIMEApplicationJSON, http.StatusNotFound, response.CodeNotFound
+			tc.run(t, e, token)
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Authorization", token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET %s as a maintainer: status = %d, want %d\n%s", tc.path, rec.Code, http.StatusOK, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	_, e, token := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/dependencies/secret", nil)
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	dependencies := map[string][]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &dependencies); err != nil {
+		t.Fatalf("%v\n%s", err, rec.Body.String())
+	}
+
+	want := "https://r.justjs.dev/known/_/1.1.0/known.tgz"
+	if got := dependencies["1.0.0"]; len(got) != 1 || got[0] != want {
+		t.Errorf("dependencies of secret@1.0.0 = %v, want [%s]", got, want)
+	}
+}
+
+// every test serves a new app, so the index has to be created for each of
+// them rather than once per process
+func TestSearch(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		_, e, _ := newTestServer(t)
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=known", nil))
+
+		result := struct {
+			Packages []struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"packages"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%v\n%s", err, rec.Body.String())
+		}
+
+		if len(result.Packages) != 1 || result.Packages[0].Name != "known" || result.Packages[0].Version != "1.1.0" {
+			t.Errorf("search for known = %s", rec.Body.String())
+		}
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	app, e, token := newTestServer(t)
+
+	// the fixtures have no tarballs, which must not be served as an empty
+	// or truncated zip
+	lookupCase{
+		path:        "/api/v1/templates/starter.zip",
+		status:      http.StatusInternalServerError,
+		contentType: echo.MIMEApplicationJSON,
+		code:        response.CodeInternal,
+	}.run(t, e, token)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/templates", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"starter"`) {
+		t.Fatalf("catalog: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// listing the catalog only reads the stored archives
+	pkg, err := store.FindPackage(app, "starter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := store.Versions(app, pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range versions {
+		if archive := version.GetString("archive"); archive != "" {
+			t.Errorf("the catalog stored an archive on %s: %s", version.GetString("version"), archive)
+		}
+	}
+}
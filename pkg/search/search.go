@@ -0,0 +1,341 @@
+package search
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"registry/pkg/blob"
+	"registry/pkg/helpers"
+	"registry/pkg/store"
+	"registry/pkg/types"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+const table = "just_search"
+const maxReadme = 64 * 1024
+
+// column weights used for ranking, in the order of the indexed columns
+var weights = []struct {
+	column string
+	weight float64
+}{
+	{"name", 10},
+	{"keywords", 5},
+	{"description", 3},
+	{"author", 2},
+	{"readme", 1},
+}
+
+// lock serializes creating the index, its state is read from the
+// database on every use so it always matches the app it is called with
+var lock sync.Mutex
+
+type Options struct {
+	Query   string
+	License string
+	Group   string
+	Types   string
+	Page    int
+	PerPage int
+}
+
+type Hit struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Description string   `json:"description"`
+	Keywords    []string `json:"keywords"`
+	Author      string   `json:"author"`
+	License     string   `json:"license"`
+	Group       string   `json:"group"`
+	Types       bool     `json:"types"`
+	Score       float64  `json:"score"`
+}
+
+type row struct {
+	Name        string  `db:"name"`
+	Version     string  `db:"version"`
+	Description string  `db:"description"`
+	Keywords    string  `db:"keywords"`
+	Author      string  `db:"author"`
+	License     string  `db:"license"`
+	Group       string  `db:"grp"`
+	Types       bool    `db:"types"`
+	Score       float64 `db:"score"`
+}
+
+// Ensure creates the search index, using FTS5 when the SQLite build
+// supports it and a plain table otherwise, and fills it when it is new.
+func Ensure(app core.App) error {
+	_, err := ensure(app)
+	return err
+}
+
+// ensure is Ensure, reporting whether the index is an FTS5 table.
+func ensure(app core.App) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var definition string
+	app.DB().NewQuery("SELECT [[sql]] FROM sqlite_master WHERE [[type]] = 'table' AND [[name]] = {:name}").
+		Bind(dbx.Params{"name": table}).
+		Row(&definition)
+
+	if definition != "" {
+		return strings.Contains(strings.ToLower(definition), "fts5"), nil
+	}
+
+	columns := []string{}
+	for _, w := range weights {
+		columns = append(columns, w.column)
+	}
+
+	fts := true
+	_, err := app.DB().NewQuery(fmt.Sprintf(
+		"CREATE VIRTUAL TABLE %s USING fts5(%s, package UNINDEXED, version UNINDEXED, license UNINDEXED, grp UNINDEXED, types UNINDEXED, tokenize = 'unicode61')",
+		table, strings.Join(columns, ", "),
+	)).Execute()
+
+	if err != nil {
+		log.Printf("search: FTS5 is not available (%v), falling back to plain matching", err)
+		fts = false
+
+		_, err = app.DB().NewQuery(fmt.Sprintf(
+			"CREATE TABLE %s (%s TEXT, package TEXT PRIMARY KEY, version TEXT, license TEXT, grp TEXT, types BOOLEAN)",
+			table, strings.Join(columns, " TEXT, "),
+		)).Execute()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return fts, reindex(app)
+}
+
+// Register keeps the index in sync with changes made outside of
+// publishing: metadata and visibility edits, maintainer changes and deleted
+// packages or versions.
+func Register(app core.App) {
+	app.OnModelAfterUpdate().Add(func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*models.Record); ok && record.Collection().Name == store.PackagesCollection {
+			refresh(app, record)
+		}
+		return nil
+	})
+
+	app.OnModelAfterDelete().Add(func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		switch record.Collection().Name {
+		case store.PackagesCollection:
+			refresh(app, record)
+		case store.VersionsCollection:
+			if pkg, err := app.Dao().FindRecordById(store.PackagesCollection, record.GetString("package")); err == nil {
+				refresh(app, pkg)
+			}
+		}
+		return nil
+	})
+}
+
+func refresh(app core.App, pkg *models.Record) {
+	if err := Index(app, pkg); err != nil {
+		log.Printf("search: failed to index '%s': %v", pkg.GetString("name"), err)
+	}
+}
+
+func reindex(app core.App) error {
+	if exists, _ := app.Dao().FindCollectionByNameOrId(store.PackagesCollection); exists == nil {
+		return nil
+	}
+
+	packages, err := app.Dao().FindRecordsByExpr(store.PackagesCollection)
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range packages {
+		if err := index(app, pkg); err != nil {
+			log.Printf("search: failed to index '%s': %v", pkg.GetString("name"), err)
+		}
+	}
+
+	return nil
+}
+
+// Index replaces the entry of a package with its current metadata and the
+// author, readme and types of its latest version. Private packages and
+// packages without versions are removed from the index.
+func Index(app core.App, pkg *models.Record) error {
+	if err := Ensure(app); err != nil {
+		return err
+	}
+
+	return index(app, pkg)
+}
+
+func index(app core.App, pkg *models.Record) error {
+	if _, err := app.DB().Delete(table, dbx.HashExp{"package": pkg.Id}).Execute(); err != nil {
+		return err
+	}
+
+	latest, err := store.Latest(app, pkg)
+	if err != nil || !store.IsPublic(pkg) {
+		return nil
+	}
+
+	readme := helpers.Truncate(latest.GetString("readme"), maxReadme)
+
+	hasTypes, _ := helpers.HasTypes(blob.Tarball(app, latest))
+
+	_, err = app.DB().Insert(table, dbx.Params{
+		"name":        pkg.GetString("name"),
+		"keywords":    strings.Join(pkg.GetStringSlice("keywords"), " "),
+		"description": pkg.GetString("description"),
+		"author":      latest.GetString("author"),
+		"readme":      readme,
+		"package":     pkg.Id,
+		"version":     latest.GetString("version"),
+		"license":     pkg.GetString("license"),
+		"grp":         pkg.GetString("group"),
+		"types":       hasTypes,
+	}).Execute()
+
+	return err
+}
+
+func terms(query string) []string {
+	result := []string{}
+	for _, term := range strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ',' || r == '"'
+	}) {
+		result = append(result, term)
+	}
+
+	return result
+}
+
+func Search(app core.App, options Options) (*types.Result, error) {
+	fts, err := ensure(app)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Page < 1 {
+		options.Page = 1
+	}
+
+	if options.PerPage < 1 || options.PerPage > 100 {
+		options.PerPage = 30
+	}
+
+	filters := []dbx.Expression{}
+	params := dbx.Params{}
+	score := "0"
+	words := terms(options.Query)
+
+	if len(words) > 0 && fts {
+		match := []string{}
+		for _, word := range words {
+			match = append(match, fmt.Sprintf(`"%s"*`, word))
+		}
+
+		ranks := []string{}
+		for _, w := range weights {
+			ranks = append(ranks, fmt.Sprintf("%.1f", w.weight))
+		}
+
+		filters = append(filters, dbx.NewExp(table+" MATCH {:match}", dbx.Params{"match": strings.Join(match, " ")}))
+		score = fmt.Sprintf("-bm25(%s, %s)", table, strings.Join(ranks, ", "))
+	} else if len(words) > 0 {
+		scores := []string{}
+		for i, word := range words {
+			key := fmt.Sprintf("term%d", i)
+			params[key] = "%" + word + "%"
+
+			columns := []dbx.Expression{}
+			for _, w := range weights {
+				columns = append(columns, dbx.NewExp(fmt.Sprintf("LOWER([[%s]]) LIKE {:%s}", w.column, key)))
+				scores = append(scores, fmt.Sprintf("(CASE WHEN LOWER([[%s]]) LIKE {:%s} THEN %.1f ELSE 0 END)", w.column, key, w.weight))
+			}
+			filters = append(filters, dbx.Or(columns...))
+		}
+		score = strings.Join(scores, " + ")
+	}
+
+	if len(words) > 0 {
+		params["exact"] = strings.ToLower(strings.TrimSpace(options.Query))
+		params["prefix"] = params["exact"].(string) + "%"
+		score = fmt.Sprintf("(%s) + (CASE WHEN LOWER([[name]]) = {:exact} THEN 100 WHEN LOWER([[name]]) LIKE {:prefix} THEN 10 ELSE 0 END)", score)
+	}
+
+	if options.License != "" {
+		filters = append(filters, dbx.NewExp("LOWER([[license]]) = {:license}", dbx.Params{"license": strings.ToLower(options.License)}))
+	}
+
+	switch options.Group {
+	case "":
+	case "local", "net":
+		filters = append(filters, dbx.In("grp", options.Group, "both"))
+	default:
+		filters = append(filters, dbx.HashExp{"grp": options.Group})
+	}
+
+	switch options.Types {
+	case "true", "1":
+		filters = append(filters, dbx.NewExp("[[types]] = 1"))
+	case "false", "0":
+		filters = append(filters, dbx.NewExp("[[types]] = 0"))
+	}
+
+	where := dbx.And(filters...)
+
+	var total int
+	if err := app.DB().Select("COUNT(*)").From(table).Where(where).Bind(params).Row(&total); err != nil {
+		return nil, err
+	}
+
+	rows := []row{}
+	err = app.DB().
+		Select("name", "version", "description", "keywords", "author", "license", "grp", "types", "("+score+") AS score").
+		From(table).
+		Where(where).
+		Bind(params).
+		OrderBy("score DESC", "name ASC").
+		Limit(int64(options.PerPage)).
+		Offset(int64((options.Page - 1) * options.PerPage)).
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := []Hit{}
+	for _, r := range rows {
+		hits = append(hits, Hit{
+			Name:        r.Name,
+			Version:     r.Version,
+			Description: r.Description,
+			Keywords:    strings.Fields(r.Keywords),
+			Author:      r.Author,
+			License:     r.License,
+			Group:       r.Group,
+			Types:       r.Types,
+			Score:       r.Score,
+		})
+	}
+
+	return &types.Result{
+		Page:       options.Page,
+		PerPage:    options.PerPage,
+		TotalItems: total,
+		TotalPages: (total + options.PerPage - 1) / options.PerPage,
+		Packages:   hits,
+	}, nil
+}
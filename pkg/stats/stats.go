@@ -0,0 +1,413 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"registry/pkg/store"
+
+	"github.com/labstack/echo/v5"
+	"github.com/mileusna/useragent"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cobra"
+)
+
+const downloadsCollection = "just_downloads"
+const dayLayout = "2006-01-02"
+
+var botPattern = regexp.MustCompile(`(?i)(bot|crawler|spider|slurp|headlesschrome)([/ ;)]|$)`)
+
+type key struct {
+	pkg     string
+	version string
+	day     string
+}
+
+type recorder struct {
+	app      core.App
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[key]int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var active = &recorder{pending: map[key]int{}}
+
+func Register(app core.App, rootCmd *cobra.Command) {
+	active.app = app
+
+	rootCmd.PersistentFlags().DurationVar(
+		&active.interval,
+		"statsFlush",
+		30*time.Second,
+		"interval for writing buffered download counts to the database",
+	)
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if err := ensure(app); err != nil {
+			return err
+		}
+
+		active.stop = make(chan struct{})
+		active.done = make(chan struct{})
+		go active.flusher()
+		return nil
+	})
+}
+
+func ensure(app core.App) error {
+	if exists, _ := app.Dao().FindCollectionByNameOrId(downloadsCollection); exists != nil {
+		return nil
+	}
+
+	collection := &models.Collection{
+		Name: downloadsCollection,
+		Type: models.CollectionTypeBase,
+		Schema: schema.NewSchema(
+			&schema.SchemaField{Name: "package", Type: schema.FieldTypeText, Required: true},
+			&schema.SchemaField{Name: "version", Type: schema.FieldTypeText, Required: true},
+			&schema.SchemaField{Name: "day", Type: schema.FieldTypeText, Required: true},
+			&schema.SchemaField{Name: "count", Type: schema.FieldTypeNumber, Required: true},
+		),
+	}
+
+	if err := app.Dao().SaveCollection(collection); err != nil {
+		return err
+	}
+
+	_, err := app.DB().NewQuery(
+		"CREATE UNIQUE INDEX IF NOT EXISTS [[idx_just_downloads_package_version_day]] ON {{just_downloads}} ([[package]], [[version]], [[day]])",
+	).Execute()
+
+	return err
+}
+
+// IsBot reports whether a request should not be counted as a download.
+func IsBot(c echo.Context) bool {
+	if c.Request().Method != http.MethodGet {
+		return true
+	}
+
+	userAgent := c.Request().UserAgent()
+	return useragent.Parse(userAgent).Bot || botPattern.MatchString(userAgent)
+}
+
+// Record counts one download of a package version. Counts are kept in
+// memory and written in batches by the flusher.
+func Record(c echo.Context, packageName string, version string) {
+	if IsBot(c) {
+		return
+	}
+
+	active.mu.Lock()
+	defer active.mu.Unlock()
+
+	active.pending[key{packageName, version, time.Now().UTC().Format(dayLayout)}]++
+}
+
+func (r *recorder) flusher() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+
+		if err := Flush(); err != nil {
+			log.Printf("stats: failed to write download counts: %v", err)
+		}
+	}
+}
+
+// Close stops the flusher and writes the counts that are still buffered.
+// PocketBase v0.10 has no terminate hook and closes the database as soon as
+// it is signalled to stop, so Close runs after it returned and reopens the
+// database when there is anything left to write.
+func Close(app core.App) error {
+	if active.stop != nil {
+		close(active.stop)
+		<-active.done
+		active.stop = nil
+	}
+
+	active.mu.Lock()
+	pending := len(active.pending)
+	active.mu.Unlock()
+
+	if pending == 0 {
+		return nil
+	}
+
+	if !app.IsBootstrapped() {
+		if err := app.Bootstrap(); err != nil {
+			return err
+		}
+		defer app.ResetBootstrapState()
+	}
+
+	return Flush()
+}
+
+// Flush writes the buffered counts in a single transaction. Counts that
+// fail to be written are kept for the next attempt.
+func Flush() error {
+	active.mu.Lock()
+	batch := active.pending
+	active.pending = map[key]int{}
+	active.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := errors.New("the database is closed")
+	if active.app.IsBootstrapped() {
+		err = flush(batch)
+	}
+
+	if err != nil {
+		active.mu.Lock()
+		for k, count := range batch {
+			active.pending[k] += count
+		}
+		active.mu.Unlock()
+	}
+
+	return err
+}
+
+func flush(batch map[key]int) error {
+	return active.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		now := types.NowDateTime().String()
+
+		for k, count := range batch {
+			result, err := txDao.DB().NewQuery(
+				"UPDATE {{just_downloads}} SET [[count]] = [[count]] + {:count}, [[updated]] = {:now} WHERE [[package]] = {:package} AND [[version]] = {:version} AND [[day]] = {:day}",
+			).Bind(dbx.Params{
+				"package": k.pkg,
+				"version": k.version,
+				"day":     k.day,
+				"count":   count,
+				"now":     now,
+			}).Execute()
+			if err != nil {
+				return err
+			}
+
+			if updated, _ := result.RowsAffected(); updated > 0 {
+				continue
+			}
+
+			_, err = txDao.DB().Insert(downloadsCollection, dbx.Params{
+				"id":      security.RandomStringWithAlphabet(models.DefaultIdLength, models.DefaultIdAlphabet),
+				"package": k.pkg,
+				"version": k.version,
+				"day":     k.day,
+				"count":   count,
+				"created": now,
+				"updated": now,
+			}).Execute()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+type Point struct {
+	Downloads int    `json:"downloads"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Package   string `json:"package,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+type Day struct {
+	Day       string `json:"day"`
+	Downloads int    `json:"downloads"`
+}
+
+type Range struct {
+	Downloads []Day  `json:"downloads"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Package   string `json:"package,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+const maxDays = 550
+
+// ParsePeriod accepts last-day, last-week, last-month, last-year, a single
+// day or a start:end pair of days and returns the inclusive bounds.
+func ParsePeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	switch period {
+	case "last-day":
+		return today, today, nil
+	case "last-week":
+		return today.AddDate(0, 0, -6), today, nil
+	case "last-month":
+		return today.AddDate(0, 0, -29), today, nil
+	case "last-year":
+		return today.AddDate(0, 0, -364), today, nil
+	}
+
+	from, to, found := strings.Cut(period, ":")
+	if !found {
+		to = from
+	}
+
+	start, err := time.Parse(dayLayout, from)
+	if err != nil {
+		return start, start, fmt.Errorf("invalid period '%s'", period)
+	}
+
+	end, err := time.Parse(dayLayout, to)
+	if err != nil {
+		return start, end, fmt.Errorf("invalid period '%s'", period)
+	}
+
+	if end.Before(start) {
+		return start, end, fmt.Errorf("period '%s' ends before it starts", period)
+	}
+
+	if end.Sub(start) > maxDays*24*time.Hour {
+		return start, end, fmt.Errorf("period '%s' is longer than %d days", period, maxDays)
+	}
+
+	return start, end, nil
+}
+
+func counts(app core.App, packageName string, version string, start time.Time, end time.Time) (map[string]int, error) {
+	from, to := start.Format(dayLayout), end.Format(dayLayout)
+	result := map[string]int{}
+
+	if exists, _ := app.Dao().FindCollectionByNameOrId(store.PackagesCollection); exists == nil {
+		return result, nil
+	}
+
+	if exists, _ := app.Dao().FindCollectionByNameOrId(store.PackagesCollection); exists == nil {
+		return result, nil
+	}
+
+	if exists, _ := app.Dao().FindCollectionByNameOrId(downloadsCollection); exists != nil {
+		filters := []dbx.Expression{dbx.Between("day", from, to)}
+		if packageName != "" {
+			filters = append(filters, dbx.HashExp{"package": packageName})
+		} else {
+			// totals must not reveal the downloads of private packages
+			filters = append(filters, dbx.NewExp("[[package]] IN (SELECT [[name]] FROM {{just_packages}} WHERE [[visibility]] = 'public')"))
+		}
+		if version != "" {
+			filters = append(filters, dbx.HashExp{"version": version})
+		}
+
+		rows := []Day{}
+		err := app.DB().
+			Select("day", "SUM([[count]]) AS downloads").
+			From(downloadsCollection).
+			Where(dbx.And(filters...)).
+			GroupBy("day").
+			All(&rows)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			result[row.Day] = row.Downloads
+		}
+	}
+
+	active.mu.Lock()
+	pending := map[key]int{}
+	for k, count := range active.pending {
+		pending[k] = count
+	}
+	active.mu.Unlock()
+
+	public := map[string]bool{}
+	for k, count := range pending {
+		if k.day < from || k.day > to || (packageName != "" && k.pkg != packageName) || (version != "" && k.version != version) {
+			continue
+		}
+
+		if packageName == "" {
+			if _, checked := public[k.pkg]; !checked {
+				pkg, err := store.FindPackage(app, k.pkg)
+				public[k.pkg] = err == nil && store.IsPublic(pkg)
+			}
+			if !public[k.pkg] {
+				continue
+			}
+		}
+
+		result[k.day] += count
+	}
+
+	return result, nil
+}
+
+// Downloads returns the total of a package, or of all packages when the
+// name is empty, optionally limited to one version.
+func Downloads(app core.App, packageName string, version string, period string) (*Point, error) {
+	start, end, err := ParsePeriod(period, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := counts(app, packageName, version, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	point := &Point{Start: start.Format(dayLayout), End: end.Format(dayLayout), Package: packageName, Version: version}
+	for _, count := range days {
+		point.Downloads += count
+	}
+
+	return point, nil
+}
+
+// DailyDownloads is like Downloads but returns one entry for every day of
+// the period, including days without downloads.
+func DailyDownloads(app core.App, packageName string, version string, period string) (*Range, error) {
+	start, end, err := ParsePeriod(period, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := counts(app, packageName, version, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Range{Downloads: []Day{}, Start: start.Format(dayLayout), End: end.Format(dayLayout), Package: packageName, Version: version}
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		result.Downloads = append(result.Downloads, Day{Day: day.Format(dayLayout), Downloads: days[day.Format(dayLayout)]})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,28 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// NotFoundError is returned when a package, or a version of it, does not
+// exist. It matches ErrNotFound with errors.Is so callers can tell it apart
+// from database failures.
+type NotFoundError struct {
+	Package string
+	Version string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Version == "" {
+		return fmt.Sprintf("package '%s' not found", e.Package)
+	}
+
+	return fmt.Sprintf("version '%s' of '%s' not found", e.Version, e.Package)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
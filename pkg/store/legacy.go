@@ -0,0 +1,224 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"registry/pkg/blob"
+	"registry/pkg/parse"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func Consolidate(dao *daos.Dao) error {
+	collections, err := dao.FindCollectionsByType(models.CollectionTypeBase)
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		if !IsLegacyCollection(collection) {
+			continue
+		}
+
+		if err := ConsolidateCollection(dao, collection); err != nil {
+			return fmt.Errorf("%s: %w", parse.OriginalName(collection.Name), err)
+		}
+	}
+
+	return nil
+}
+
+// ConsolidateCollection moves the package fields of a version collection
+// created before metadata records existed into a single metadata record,
+// taking the values of the latest version, and links every version to it.
+func ConsolidateCollection(dao *daos.Dao, collection *models.Collection) error {
+	if collection.Schema.GetFieldByName("package") != nil {
+		return nil
+	}
+
+	packages, err := EnsurePackages(dao)
+	if err != nil {
+		return err
+	}
+
+	name := parse.OriginalName(collection.Name)
+	pkg, _ := dao.FindFirstRecordByData(PackagesCollection, "name", name)
+
+	if pkg == nil {
+		records, err := dao.FindRecordsByExpr(collection.Name)
+		if err != nil {
+			return err
+		}
+
+		pkg = models.NewRecord(packages)
+		pkg.Set("name", name)
+		pkg.Set("type", "package")
+
+		if len(records) > 0 {
+			latest := records[len(records)-1]
+			for _, field := range Fields {
+				if value := latest.Get(field); value != nil && value != "" {
+					pkg.Set(field, value)
+				}
+			}
+		}
+
+		if err := dao.SaveRecord(pkg); err != nil {
+			return err
+		}
+	}
+
+	relation := PackageRelation(packages)
+	relation.Required = false
+	collection.Schema.AddField(relation)
+
+	if err := dao.SaveCollection(collection); err != nil {
+		return err
+	}
+
+	if _, err := dao.DB().Update(collection.Name, dbx.Params{"package": pkg.Id}, nil).Execute(); err != nil {
+		return err
+	}
+
+	for _, field := range Fields {
+		if existing := collection.Schema.GetFieldByName(field); existing != nil {
+			collection.Schema.RemoveField(existing.Id)
+		}
+	}
+
+	collection.Schema.GetFieldByName("package").Required = true
+	collection.ListRule = types.Pointer("@request.auth.id = package.access.id")
+	collection.ViewRule = types.Pointer("@request.auth.id = package.access.id")
+
+	return dao.SaveCollection(collection)
+}
+
+func IsLegacyCollection(collection *models.Collection) bool {
+	return collection.Type == models.CollectionTypeBase &&
+		collection.Name != VersionsCollection &&
+		collection.Schema.GetFieldByName("tarball") != nil
+}
+
+// MoveLegacy copies the versions of every per-package collection into the
+// shared versions collection, keeping record ids and timestamps, and drops
+// the old collections. Tarballs are copied within storage before anything
+// is dropped, a tarball that is missing or fails to copy aborts the move.
+func MoveLegacy(dao *daos.Dao, storage blob.Storage) error {
+	collections, err := dao.FindCollectionsByType(models.CollectionTypeBase)
+	if err != nil {
+		return err
+	}
+
+	legacy := []*models.Collection{}
+	for _, collection := range collections {
+		if IsLegacyCollection(collection) {
+			legacy = append(legacy, collection)
+		}
+	}
+
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	versions, err := EnsureVersions(dao)
+	if err != nil {
+		return err
+	}
+
+	moves := [][2]string{}
+	for _, collection := range legacy {
+		if err := ConsolidateCollection(dao, collection); err != nil {
+			return fmt.Errorf("%s: %w", parse.OriginalName(collection.Name), err)
+		}
+
+		records, err := dao.FindRecordsByExpr(collection.Name)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			existing, _ := dao.FindRecordsByExpr(VersionsCollection, dbx.HashExp{
+				"package": record.GetString("package"),
+				"version": record.GetString("version"),
+			})
+			if len(existing) > 0 {
+				continue
+			}
+
+			moved := models.NewRecord(versions)
+			moved.SetId(record.Id)
+			moved.MarkAsNew()
+			moved.Created = record.Created
+			moved.Updated = record.Updated
+
+			for _, field := range []string{"package", "version", "index", "author", "dependencies", "tarball"} {
+				moved.Set(field, record.Get(field))
+			}
+
+			if err := dao.SaveRecord(moved); err != nil {
+				return fmt.Errorf("%s@%s: %w", parse.OriginalName(collection.Name), record.GetString("version"), err)
+			}
+
+			if tarball := record.GetString("tarball"); tarball != "" {
+				moves = append(moves, [2]string{
+					record.BaseFilesPath() + "/" + tarball,
+					moved.BaseFilesPath() + "/" + tarball,
+				})
+			}
+		}
+	}
+
+	if err := copyFiles(storage, moves); err != nil {
+		return err
+	}
+
+	for _, collection := range legacy {
+		if err := dao.DeleteCollection(collection); err != nil {
+			return err
+		}
+	}
+
+	for _, move := range moves {
+		if err := storage.Delete(move[0]); err != nil {
+			log.Printf("store: unable to remove legacy tarball %s: %v", move[0], err)
+		}
+	}
+
+	return nil
+}
+
+// copyFiles copies every source key to its destination. When a copy fails
+// the copies made so far are removed again and the sources are left alone.
+func copyFiles(storage blob.Storage, moves [][2]string) error {
+	for i, move := range moves {
+		err := copyFile(storage, move[0], move[1])
+		if err != nil {
+			for _, done := range moves[:i] {
+				storage.Delete(done[1])
+			}
+			return fmt.Errorf("%s: %w", move[0], err)
+		}
+	}
+
+	return nil
+}
+
+func copyFile(storage blob.Storage, from string, to string) error {
+	reader, err := storage.Open(from)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	return storage.Write(to, content)
+}
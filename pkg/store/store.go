@@ -0,0 +1,405 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"registry/pkg/parse"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"golang.org/x/mod/semver"
+)
+
+const PackagesCollection = "just_packages"
+const VersionsCollection = "just_versions"
+
+var Fields = []string{"access", "visibility", "type", "group", "description", "keywords", "url", "repository", "license"}
+
+func EnsurePackages(dao *daos.Dao) (*models.Collection, error) {
+	if exists, _ := dao.FindCollectionByNameOrId(PackagesCollection); exists != nil {
+		return exists, nil
+	}
+
+	users, err := dao.FindCollectionByNameOrId("just_auth_system")
+	if err != nil {
+		return nil, err
+	}
+
+	collection := &models.Collection{
+		Name:     PackagesCollection,
+		Type:     models.CollectionTypeBase,
+		ListRule: types.Pointer(`visibility = "public" || @request.auth.id = access.id`),
+		ViewRule: types.Pointer(`visibility = "public" || @request.auth.id = access.id`),
+		Schema: schema.NewSchema(
+			&schema.SchemaField{
+				Name:     "name",
+				Type:     schema.FieldTypeText,
+				Required: true,
+				Unique:   true,
+			},
+			&schema.SchemaField{
+				Name:     "access",
+				Type:     schema.FieldTypeRelation,
+				Required: true,
+				Unique:   false,
+				Options: &schema.RelationOptions{
+					CollectionId:  users.Id,
+					CascadeDelete: false,
+				},
+			},
+			&schema.SchemaField{
+				Name:     "visibility",
+				Type:     schema.FieldTypeSelect,
+				Required: true,
+				Unique:   false,
+				Options: &schema.SelectOptions{
+					MaxSelect: 1,
+					Values:    []string{"public", "private"},
+				},
+			},
+			&schema.SchemaField{
+				Name:     "type",
+				Type:     schema.FieldTypeSelect,
+				Required: false,
+				Unique:   false,
+				Options: &schema.SelectOptions{
+					MaxSelect: 1,
+					Values:    []string{"package", "template"},
+				},
+			},
+			&schema.SchemaField{
+				Name:     "group",
+				Type:     schema.FieldTypeSelect,
+				Required: true,
+				Unique:   false,
+				Options: &schema.SelectOptions{
+					MaxSelect: 1,
+					Values:    []string{"local", "net", "both"},
+				},
+			},
+			&schema.SchemaField{Name: "description", Type: schema.FieldTypeText},
+			KeywordsField(),
+			&schema.SchemaField{Name: "url", Type: schema.FieldTypeText},
+			&schema.SchemaField{Name: "repository", Type: schema.FieldTypeText},
+			&schema.SchemaField{Name: "license", Type: schema.FieldTypeText},
+		),
+	}
+
+	if err := dao.SaveCollection(collection); err != nil {
+		return nil, err
+	}
+
+	if err := createIndex(dao, PackagesCollection, "name"); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func EnsureVersions(dao *daos.Dao) (*models.Collection, error) {
+	if exists, _ := dao.FindCollectionByNameOrId(VersionsCollection); exists != nil {
+		return exists, nil
+	}
+
+	packages, err := EnsurePackages(dao)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := &models.Collection{
+		Name:     VersionsCollection,
+		Type:     models.CollectionTypeBase,
+		ListRule: types.Pointer(`package.visibility = "public" || @request.auth.id = package.access.id`),
+		ViewRule: types.Pointer(`package.visibility = "public" || @request.auth.id = package.access.id`),
+		Schema: schema.NewSchema(
+			PackageRelation(packages),
+			&schema.SchemaField{
+				Name:     "version",
+				Type:     schema.FieldTypeText,
+				Required: true,
+				Unique:   false,
+				Options: &schema.TextOptions{
+					Pattern: `^(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-((?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`,
+				},
+			},
+			&schema.SchemaField{
+				Name:     "index",
+				Type:     schema.FieldTypeText,
+				Required: true,
+				Unique:   false,
+			},
+			&schema.SchemaField{
+				Name:     "author",
+				Type:     schema.FieldTypeText,
+				Required: true,
+				Unique:   false,
+			},
+			&schema.SchemaField{
+				Name:     "dependencies",
+				Type:     schema.FieldTypeJson,
+				Required: false,
+				Unique:   false,
+			},
+			&schema.SchemaField{
+				Name:     "tarball",
+				Type:     schema.FieldTypeFile,
+				Required: true,
+				Unique:   false,
+				Options: &schema.FileOptions{
+					MaxSelect: 1,
+					MaxSize:   10485760,
+					MimeTypes: []string{"application/gzip"},
+				},
+			},
+		),
+	}
+
+	for _, field := range DocumentFields() {
+		collection.Schema.AddField(field)
+	}
+	collection.Schema.AddField(BuildsField())
+	collection.Schema.AddField(ArchiveField())
+
+	if err := dao.SaveCollection(collection); err != nil {
+		return nil, err
+	}
+
+	if err := createIndex(dao, VersionsCollection, "package", "version"); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+// CreateIndexes adds the unique indexes to collections created before they
+// were part of the schema.
+func CreateIndexes(dao *daos.Dao) error {
+	if exists, _ := dao.FindCollectionByNameOrId(PackagesCollection); exists != nil {
+		if err := createIndex(dao, PackagesCollection, "name"); err != nil {
+			return err
+		}
+	}
+
+	if exists, _ := dao.FindCollectionByNameOrId(VersionsCollection); exists != nil {
+		if err := createIndex(dao, VersionsCollection, "package", "version"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createIndex(dao *daos.Dao, table string, columns ...string) error {
+	name := fmt.Sprintf("idx_%s_%s", table, strings.Join(columns, "_"))
+	_, err := dao.DB().NewQuery(fmt.Sprintf(
+		"CREATE UNIQUE INDEX IF NOT EXISTS [[%s]] ON {{%s}} ([[%s]])",
+		name, table, strings.Join(columns, "]], [["),
+	)).Execute()
+
+	return err
+}
+
+func KeywordsField() *schema.SchemaField {
+	return &schema.SchemaField{
+		Name:     "keywords",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Unique:   false,
+	}
+}
+
+// DocumentFields holds the readme and changelog extracted from the tarball
+// when a version is published.
+func DocumentFields() []*schema.SchemaField {
+	return []*schema.SchemaField{
+		{Name: "readme", Type: schema.FieldTypeText},
+		{Name: "changelog", Type: schema.FieldTypeText},
+	}
+}
+
+// BuildsField holds the state of the prebuild of a version and the outcome
+// for every file and target.
+func BuildsField() *schema.SchemaField {
+	return &schema.SchemaField{Name: "builds", Type: schema.FieldTypeJson}
+}
+
+// ArchiveField holds the files, hash and size of the zip a template version
+// is served as.
+func ArchiveField() *schema.SchemaField {
+	return &schema.SchemaField{Name: "archive", Type: schema.FieldTypeJson}
+}
+
+func PackageRelation(packages *models.Collection) *schema.SchemaField {
+	return &schema.SchemaField{
+		Name:     "package",
+		Type:     schema.FieldTypeRelation,
+		Required: true,
+		Unique:   false,
+		Options: &schema.RelationOptions{
+			MaxSelect:     types.Pointer(1),
+			CollectionId:  packages.Id,
+			CascadeDelete: false,
+		},
+	}
+}
+
+func FindPackage(app core.App, name string) (*models.Record, error) {
+	record, err := app.Dao().FindFirstRecordByData(PackagesCollection, "name", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &NotFoundError{Package: name}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func IsPublic(pkg *models.Record) bool {
+	return pkg.GetString("visibility") == "public"
+}
+
+func Versions(app core.App, pkg *models.Record, exprs ...dbx.Expression) ([]*models.Record, error) {
+	collection, err := app.Dao().FindCollectionByNameOrId(VersionsCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	query := app.Dao().RecordQuery(collection).
+		AndWhere(dbx.HashExp{"package": pkg.Id}).
+		OrderBy("created ASC", "rowid ASC")
+
+	for _, expr := range exprs {
+		if expr != nil {
+			query.AndWhere(expr)
+		}
+	}
+
+	rows := []dbx.NullStringMap{}
+	if err := query.All(&rows); err != nil {
+		return nil, err
+	}
+
+	return models.NewRecordsFromNullStringMaps(collection, rows), nil
+}
+
+func FindVersion(app core.App, pkg *models.Record, version string) (*models.Record, error) {
+	records, err := Versions(app, pkg, dbx.HashExp{"version": version})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, &NotFoundError{Package: pkg.GetString("name"), Version: version}
+	}
+
+	return records[0], nil
+}
+
+// Latest returns the highest stable version of pkg, or its highest
+// prerelease when it has no stable versions yet.
+func Latest(app core.App, pkg *models.Record) (*models.Record, error) {
+	record, err := Resolve(app, pkg, "*")
+	if !errors.Is(err, ErrNotFound) {
+		return record, err
+	}
+
+	records, err := Versions(app, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	if record := highest(records); record != nil {
+		return record, nil
+	}
+
+	return nil, &NotFoundError{Package: pkg.GetString("name"), Version: "latest"}
+}
+
+// Resolve returns the highest version of pkg that satisfies the range spec.
+func Resolve(app core.App, pkg *models.Record, spec string) (*models.Record, error) {
+	// a range that can't be parsed can't match a version either
+	if !parse.ValidRange(spec) {
+		return nil, &NotFoundError{Package: pkg.GetString("name"), Version: spec}
+	}
+
+	records, err := Versions(app, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []*models.Record{}
+	for _, record := range records {
+		if ok, _ := parse.Satisfies(record.GetString("version"), spec); ok {
+			matching = append(matching, record)
+		}
+	}
+
+	resolved := highest(matching)
+	if resolved == nil {
+		return nil, &NotFoundError{Package: pkg.GetString("name"), Version: spec}
+	}
+
+	return resolved, nil
+}
+
+func highest(records []*models.Record) *models.Record {
+	var result *models.Record
+	for _, record := range records {
+		if result == nil || semver.Compare("v"+record.GetString("version"), "v"+result.GetString("version")) > 0 {
+			result = record
+		}
+	}
+
+	return result
+}
+
+// SaveDocuments stores the readme and changelog of a version.
+func SaveDocuments(dao *daos.Dao, record *models.Record, readme string, changelog string) error {
+	record.Set("readme", readme)
+	record.Set("changelog", changelog)
+
+	return dao.SaveRecord(record)
+}
+
+// SavePackage creates the metadata record for name or updates the fields
+// present in data. Access is only taken from data when the package is new.
+func SavePackage(app core.App, name string, data map[string]any) (*models.Record, error) {
+	packages, err := EnsurePackages(app.Dao())
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]any{}
+	record, _ := app.Dao().FindFirstRecordByData(PackagesCollection, "name", name)
+	if record == nil {
+		record = models.NewRecord(packages)
+		values = map[string]any{"name": name, "visibility": "public", "type": "package", "group": "both"}
+	} else {
+		delete(data, "access")
+	}
+
+	for _, field := range Fields {
+		if value, ok := data[field]; ok && value != nil && value != "" {
+			values[field] = value
+		}
+	}
+
+	form := forms.NewRecordUpsert(app, record)
+	if err := form.LoadData(values); err != nil {
+		return nil, err
+	}
+
+	if err := form.Submit(); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
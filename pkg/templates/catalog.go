@@ -0,0 +1,209 @@
+package templates
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/mod/semver"
+)
+
+const catalogName = "catalog.json"
+const manifestName = "template.json"
+
+type Manifest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Version     string   `json:"version"`
+	Runtime     []string `json:"runtime"`
+}
+
+type Release struct {
+	Version string   `json:"version"`
+	Runtime []string `json:"runtime"`
+	Files   []string `json:"files"`
+	Sha256  string   `json:"sha256"`
+	Size    int64    `json:"size"`
+	Archive string   `json:"archive"`
+}
+
+type Template struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Latest      string    `json:"latest"`
+	Archive     string    `json:"archive"`
+	Releases    []Release `json:"releases"`
+}
+
+type Catalog struct {
+	Templates []Template `json:"templates"`
+}
+
+func (t *Template) Release(version string) *Release {
+	for i := range t.Releases {
+		if t.Releases[i].Version == version {
+			return &t.Releases[i]
+		}
+	}
+	return nil
+}
+
+func LoadCatalog(dir string) (*Catalog, error) {
+	catalog := &Catalog{Templates: []Template{}}
+
+	data, err := os.ReadFile(filepath.Join(dir, catalogName))
+	if os.IsNotExist(err) {
+		return catalog, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, err
+	}
+
+	return catalog, nil
+}
+
+func (c *Catalog) Find(name string) *Template {
+	for i := range c.Templates {
+		if c.Templates[i].Name == name {
+			return &c.Templates[i]
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) save(dir string) error {
+	sort.Slice(c.Templates, func(i, j int) bool {
+		return c.Templates[i].Name < c.Templates[j].Name
+	})
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, catalogName), data, 0644)
+}
+
+func readManifest(folder string) (*Manifest, error) {
+	manifest := &Manifest{Name: filepath.Base(folder), Version: "0.0.0"}
+
+	data, err := os.ReadFile(filepath.Join(folder, manifestName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestName, err)
+	}
+
+	if strings.ContainsAny(manifest.Name, `/\@`) || strings.ContainsAny(manifest.Version, `/\@`) {
+		return nil, fmt.Errorf("%s: invalid template name or version", manifestName)
+	}
+
+	return manifest, nil
+}
+
+func listFiles(folder string) ([]string, error) {
+	files := []string{}
+
+	err := filepath.Walk(folder, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(folder, filePath)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(relPath))
+		return nil
+	})
+
+	return files, err
+}
+
+func checksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), size, nil
+}
+
+// releases from the previous catalog stay downloadable so pinned versions keep working
+func (c *Catalog) carryOver(previous *Catalog, previousDir string, dir string) error {
+	for _, old := range previous.Templates {
+		template := c.Find(old.Name)
+		if template == nil {
+			c.Templates = append(c.Templates, Template{Name: old.Name, Description: old.Description})
+			template = &c.Templates[len(c.Templates)-1]
+		}
+
+		for _, release := range old.Releases {
+			if template.Release(release.Version) != nil {
+				continue
+			}
+
+			if err := copyFile(filepath.Join(previousDir, release.Archive), filepath.Join(dir, release.Archive)); err != nil {
+				return err
+			}
+			template.Releases = append(template.Releases, release)
+		}
+
+		if template.Latest == "" {
+			template.Latest = old.Latest
+			template.Archive = old.Archive
+
+			if err := copyFile(filepath.Join(previousDir, old.Archive), filepath.Join(dir, old.Archive)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := range c.Templates {
+		sort.Slice(c.Templates[i].Releases, func(a, b int) bool {
+			return semver.Compare("v"+c.Templates[i].Releases[a].Version, "v"+c.Templates[i].Releases[b].Version) < 0
+		})
+	}
+
+	return nil
+}
+
+func copyFile(source string, destination string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return writeFile(destination, file)
+}
+
+func (r Release) SupportsRuntime(runtime string) bool {
+	return len(r.Runtime) == 0 || slices.Contains(r.Runtime, runtime)
+}
@@ -0,0 +1,207 @@
+package templates
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"registry/pkg/blob"
+	"registry/pkg/helpers"
+	"registry/pkg/store"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+var epoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func FindPublished(app core.App, name string, version string) (*models.Record, error) {
+	pkg, err := store.FindPackage(app, name)
+	if err == nil && (!store.IsPublic(pkg) || helpers.PackageType(pkg) != "template") {
+		err = &store.NotFoundError{Package: name}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if version != "" {
+		return store.FindVersion(app, pkg, version)
+	}
+
+	return store.Latest(app, pkg)
+}
+
+func Published(app core.App) ([]Template, error) {
+	if exists, _ := app.Dao().FindCollectionByNameOrId(store.PackagesCollection); exists == nil {
+		return []Template{}, nil
+	}
+
+	packages, err := app.Dao().FindRecordsByExpr(store.PackagesCollection, dbx.HashExp{"visibility": "public", "type": "template"})
+	if err != nil {
+		return nil, err
+	}
+
+	published := []Template{}
+	for _, pkg := range packages {
+		records, err := store.Versions(app, pkg)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(records) == 0 {
+			continue
+		}
+
+		latest, err := store.Latest(app, pkg)
+		if err != nil {
+			return nil, err
+		}
+
+		name := pkg.GetString("name")
+		template := Template{
+			Name:        name,
+			Description: pkg.GetString("description"),
+			Latest:      latest.GetString("version"),
+			Archive:     name + ".zip",
+		}
+
+		for _, record := range records {
+			release, err := publishedRelease(app, name, record)
+			if err != nil {
+				return nil, err
+			}
+			template.Releases = append(template.Releases, *release)
+		}
+
+		published = append(published, template)
+	}
+
+	return published, nil
+}
+
+// publishedRelease describes a version from the archive stored when it was
+// published. Listing never builds archives, versions without one are
+// listed without their files until the archive migration fills them in.
+func publishedRelease(app core.App, name string, record *models.Record) (*Release, error) {
+	archive := &Archive{}
+	if value := record.GetString("archive"); value != "" {
+		if err := json.Unmarshal([]byte(value), archive); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Release{
+		Version: record.GetString("version"),
+		Runtime: archive.Runtime,
+		Files:   archive.Files,
+		Sha256:  archive.Sha256,
+		Size:    archive.Size,
+		Archive: fmt.Sprintf("%s@%s.zip", name, record.GetString("version")),
+	}, nil
+}
+
+// Archive describes the zip a template version is served as, along with
+// the runtimes its template.json limits it to.
+type Archive struct {
+	Files   []string `json:"files"`
+	Sha256  string   `json:"sha256"`
+	Size    int64    `json:"size"`
+	Runtime []string `json:"runtime,omitempty"`
+}
+
+// NewArchive builds the zip of a template version once to describe its
+// files, hash and size, so listing templates doesn't rebuild it.
+func NewArchive(tarball blob.File, name string) (*Archive, error) {
+	hash := sha256.New()
+	counter := &countingWriter{writer: hash}
+
+	files, err := WriteZip(tarball, name, counter)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{Files: files, Sha256: fmt.Sprintf("%x", hash.Sum(nil)), Size: counter.size}
+
+	if content, err := helpers.ReadFromTar(manifestName, tarball); err == nil {
+		manifest := &Manifest{}
+		if err := json.Unmarshal(content, manifest); err != nil {
+			return nil, fmt.Errorf("%s: %w", manifestName, err)
+		}
+		archive.Runtime = manifest.Runtime
+	}
+
+	return archive, nil
+}
+
+// SaveArchive stores the archive of a template version on it.
+func SaveArchive(dao *daos.Dao, record *models.Record, archive *Archive) error {
+	record.Set("archive", archive)
+	return dao.SaveRecord(record)
+}
+
+func WriteZip(tarball blob.File, name string, w io.Writer) ([]string, error) {
+	file, err := tarball.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	archive := tar.NewReader(gz)
+	out := zip.NewWriter(w)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entryName := path.Clean("/" + header.Name)[1:]
+		entry, err := out.CreateHeader(&zip.FileHeader{
+			Name:     path.Join(name, entryName),
+			Method:   zip.Deflate,
+			Modified: epoch,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := io.Copy(entry, archive); err != nil {
+			return nil, err
+		}
+		files = append(files, entryName)
+	}
+
+	return files, out.Close()
+}
+
+type countingWriter struct {
+	writer io.Writer
+	size   int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.size += int64(n)
+	return n, err
+}
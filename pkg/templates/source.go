@@ -0,0 +1,273 @@
+package templates
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const DefaultSource = "https://github.com/exact-rs/templates"
+
+func isGitSource(source string) bool {
+	return strings.HasPrefix(source, "http://") ||
+		strings.HasPrefix(source, "https://") ||
+		strings.HasPrefix(source, "git@") ||
+		strings.HasPrefix(source, "ssh://")
+}
+
+func isRemote(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// archives are told apart by their suffix first, so a forge download url
+// isn't mistaken for a git repository
+func fetch(source string, destination string) (string, error) {
+	switch {
+	case strings.HasSuffix(source, ".tar.gz") || strings.HasSuffix(source, ".tgz"):
+		if err := fetchArchive(source, destination, fetchTarball); err != nil {
+			return "", err
+		}
+		return unwrap(destination)
+	case strings.HasSuffix(source, ".zip"):
+		if err := fetchArchive(source, destination, fetchZip); err != nil {
+			return "", err
+		}
+		return unwrap(destination)
+	case isGitSource(source):
+		return destination, fetchGit(source, destination)
+	default:
+		return destination, fetchDir(source, destination)
+	}
+}
+
+// fetchArchive extracts a local archive, or downloads a remote one to a
+// temporary file first.
+func fetchArchive(source string, destination string, extract func(string, string) error) error {
+	if !isRemote(source) {
+		return extract(source, destination)
+	}
+
+	file, err := download(source)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file)
+
+	return extract(file, destination)
+}
+
+func download(source string) (string, error) {
+	client := &http.Client{Timeout: 2 * time.Minute}
+
+	resp, err := client.Get(source)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to download '%s': %s", source, resp.Status)
+	}
+
+	out, err := os.CreateTemp("", "just-template-*")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+
+	return out.Name(), nil
+}
+
+func fetchGit(source string, destination string) error {
+	url, ref, _ := strings.Cut(source, "#")
+
+	repo, err := git.PlainClone(destination, false, &git.CloneOptions{URL: url})
+	if err != nil {
+		return err
+	}
+
+	if ref != "" {
+		hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+		if err != nil {
+			hash, err = repo.ResolveRevision(plumbing.Revision("origin/" + ref))
+		}
+		if err != nil {
+			return fmt.Errorf("unable to resolve ref '%s': %w", ref, err)
+		}
+
+		worktree, err := repo.Worktree()
+		if err != nil {
+			return err
+		}
+
+		if err := worktree.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true}); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(filepath.Join(destination, ".git"))
+}
+
+func fetchDir(source string, destination string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("template source '%s' is not a directory or a supported archive", source)
+	}
+
+	return filepath.Walk(source, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(source, filePath)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(destination, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return writeFile(target, file)
+	})
+}
+
+func fetchTarball(source string, destination string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target, err := safeJoin(destination, header.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := writeFile(target, archive); err != nil {
+			return err
+		}
+	}
+}
+
+func fetchZip(source string, destination string) error {
+	archive, err := zip.OpenReader(source)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		target, err := safeJoin(destination, entry.Name)
+		if err != nil {
+			return err
+		}
+
+		content, err := entry.Open()
+		if err != nil {
+			return err
+		}
+
+		err = writeFile(target, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func safeJoin(root string, name string) (string, error) {
+	target := filepath.Join(root, filepath.FromSlash(name))
+	if !strings.HasPrefix(target, filepath.Clean(root)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid path '%s' in archive", name)
+	}
+	return target, nil
+}
+
+func writeFile(target string, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, content)
+	return err
+}
+
+// archives from forges usually wrap everything in a single top level folder,
+// but a lone folder holding a manifest is a template and stays where it is
+func unwrap(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return dir, nil
+	}
+
+	wrapper := filepath.Join(dir, entries[0].Name())
+	if _, err := os.Stat(filepath.Join(wrapper, manifestName)); err == nil {
+		return dir, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	return wrapper, nil
+}
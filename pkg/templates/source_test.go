@@ -0,0 +1,40 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnwrap(t *testing.T) {
+	for name, tc := range map[string]struct {
+		files []string
+		want  string
+	}{
+		"forge wrapper":   {files: []string{"repo-abc123/starter/template.json", "repo-abc123/minimal/template.json"}, want: "repo-abc123"},
+		"tarball wrapper": {files: []string{"package/starter/template.json"}, want: "package"},
+		"single template": {files: []string{"starter/template.json", "starter/index.ts"}, want: "."},
+		"several folders": {files: []string{"starter/template.json", "minimal/template.json"}, want: "."},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range tc.files {
+				path := filepath.Join(dir, file)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := unwrap(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(dir, tc.want); got != want {
+				t.Errorf("unwrap = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -5,26 +5,36 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/go-git/go-git/v5"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
 )
 
+var source string
+var refresh time.Duration
+var refreshing sync.Mutex
+
 func recursiveZip(pathToZip, destinationPath string) error {
 	destinationFile, err := os.Create(destinationPath)
 	if err != nil {
 		return err
 	}
+	defer destinationFile.Close()
+
 	myZip := zip.NewWriter(destinationFile)
 	err = filepath.Walk(pathToZip, func(filePath string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
 		if err != nil {
 			return err
 		}
+		if info.IsDir() {
+			return nil
+		}
 		relPath := strings.TrimPrefix(filePath, filepath.Dir(pathToZip))
 		zipFile, err := myZip.Create(relPath)
 		if err != nil {
@@ -34,6 +44,7 @@ func recursiveZip(pathToZip, destinationPath string) error {
 		if err != nil {
 			return err
 		}
+		defer fsFile.Close()
 		_, err = io.Copy(zipFile, fsFile)
 		if err != nil {
 			return err
@@ -90,34 +101,178 @@ func listDir(dirName string) ([]string, error) {
 	return list, nil
 }
 
-func Copy() error {
-	if err := os.RemoveAll(Dir()); err != nil {
+func Register(app core.App, rootCmd *cobra.Command) {
+	rootCmd.PersistentFlags().StringVar(
+		&source,
+		"templates",
+		DefaultSource,
+		"template source: a local directory, a .tar.gz/.zip archive or a git url (pin a ref with url#ref)",
+	)
+
+	rootCmd.PersistentFlags().DurationVar(
+		&refresh,
+		"templatesRefresh",
+		0,
+		"interval for refreshing templates from their source (0 disables)",
+	)
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if files, _ := listDir(Dir()); len(files) == 0 {
+			if err := Refresh(); err != nil {
+				log.Printf("templates: unable to load templates from %s: %v", source, err)
+			}
+		}
+
+		if refresh > 0 {
+			go func() {
+				for {
+					time.Sleep(refresh)
+					if err := Refresh(); err != nil {
+						log.Printf("templates: refresh failed, keeping previous templates: %v", err)
+					}
+				}
+			}()
+		}
+
+		return nil
+	})
+}
+
+func Source() string {
+	return source
+}
+
+func Refresh() error {
+	refreshing.Lock()
+	defer refreshing.Unlock()
+
+	workDir, err := os.MkdirTemp("", "registry-templates-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	root, err := fetch(source, filepath.Join(workDir, "source"))
+	if err != nil {
 		return err
 	}
 
-	if err := ensureDir(Dir()); err != nil {
+	staging := Dir() + ".new"
+	if err := os.RemoveAll(staging); err != nil {
 		return err
 	}
 
-	if _, err := git.PlainClone(Dir(), false, &git.CloneOptions{URL: "https://github.com/exact-rs/templates", Progress: os.Stdout}); err != nil {
+	if err := ensureDir(staging); err != nil {
 		return err
 	}
 
-	if err := os.RemoveAll(fmt.Sprintf("%s/.git", Dir())); err != nil {
+	if err := build(root, staging); err != nil {
+		os.RemoveAll(staging)
 		return err
 	}
 
-	files, err := listDir(Dir())
+	return swap(staging)
+}
+
+func build(root string, destination string) error {
+	folders, err := listDir(root)
 	if err != nil {
 		return err
 	}
 
-	for _, folder := range files {
-		recursiveZip(fmt.Sprintf("%s/%s", Dir(), folder), fmt.Sprintf("%s/%s.zip", Dir(), folder))
-      if err := os.RemoveAll(fmt.Sprintf("%s/%s", Dir(), folder)); err != nil {
-         return err
-      }
+	catalog := &Catalog{Templates: []Template{}}
+	for _, folder := range folders {
+		info, err := os.Stat(filepath.Join(root, folder))
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() || strings.HasPrefix(folder, ".") {
+			continue
+		}
+
+		template, err := buildTemplate(filepath.Join(root, folder), destination)
+		if err != nil {
+			return fmt.Errorf("%s: %w", folder, err)
+		}
+
+		if catalog.Find(template.Name) != nil {
+			return fmt.Errorf("%s: duplicate template name '%s'", folder, template.Name)
+		}
+		catalog.Templates = append(catalog.Templates, *template)
 	}
 
-	return nil
+	if len(catalog.Templates) == 0 {
+		return errors.New("template source does not contain any templates")
+	}
+
+	previous, err := LoadCatalog(Dir())
+	if err != nil {
+		return err
+	}
+
+	if err := catalog.carryOver(previous, Dir(), destination); err != nil {
+		return err
+	}
+
+	return catalog.save(destination)
+}
+
+func buildTemplate(folder string, destination string) (*Template, error) {
+	manifest, err := readManifest(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := listFiles(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := fmt.Sprintf("%s@%s.zip", manifest.Name, manifest.Version)
+	if err := recursiveZip(folder, filepath.Join(destination, archive)); err != nil {
+		return nil, err
+	}
+
+	if err := copyFile(filepath.Join(destination, archive), filepath.Join(destination, manifest.Name+".zip")); err != nil {
+		return nil, err
+	}
+
+	sum, size, err := checksum(filepath.Join(destination, archive))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{
+		Name:        manifest.Name,
+		Description: manifest.Description,
+		Latest:      manifest.Version,
+		Archive:     manifest.Name + ".zip",
+		Releases: []Release{{
+			Version: manifest.Version,
+			Runtime: manifest.Runtime,
+			Files:   files,
+			Sha256:  sum,
+			Size:    size,
+			Archive: archive,
+		}},
+	}, nil
+}
+
+func swap(staging string) error {
+	previous := Dir() + ".old"
+	if err := os.RemoveAll(previous); err != nil {
+		return err
+	}
+
+	if err := os.Rename(Dir(), previous); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Rename(staging, Dir()); err != nil {
+		os.Rename(previous, Dir())
+		return err
+	}
+
+	return os.RemoveAll(previous)
 }
@@ -2,10 +2,15 @@ package main
 
 import (
 	"log"
-	"os"
 
+	"registry/migrations"
+	"registry/pkg/builds"
 	"registry/pkg/helpers"
+	"registry/pkg/just"
+	"registry/pkg/mirror"
 	"registry/pkg/routes"
+	"registry/pkg/search"
+	"registry/pkg/stats"
 	"registry/pkg/templates"
 
 	"github.com/pocketbase/pocketbase"
@@ -19,22 +24,25 @@ func main() {
 		DefaultDebug:   isUsingGoRun,
 	})
 
-	if err := routes.Router(app); err != nil {
-		log.Fatal(err)
-	}
+	migrations.Bind(app)
+	just.Register(app, app.RootCmd)
+	templates.Register(app, app.RootCmd)
+	stats.Register(app, app.RootCmd)
+	builds.Register(app, app.RootCmd)
+	search.Register(app)
+
+	app.RootCmd.AddCommand(mirror.NewExportCommand(app))
+	app.RootCmd.AddCommand(mirror.NewImportCommand(app))
 
-	version, err := helpers.GetJustVersion()
-	if err != nil {
+	if err := routes.Router(app, app.RootCmd); err != nil {
 		log.Fatal(err)
-	} else {
-		os.Setenv("JUST_VERSION", version)
 	}
 
-	if err := templates.Copy(); err != nil {
+	if err := app.Start(); err != nil {
 		log.Fatal(err)
 	}
 
-	if err := app.Start(); err != nil {
+	if err := stats.Close(app); err != nil {
 		log.Fatal(err)
 	}
 }